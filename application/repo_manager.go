@@ -6,11 +6,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
@@ -18,14 +21,26 @@ import (
 	"github.com/gookit/color"
 	"github.com/gookit/goutil/fsutil"
 	"github.com/omnibuildplatform/omni-repository/app"
+	"github.com/omnibuildplatform/omni-repository/common/checksum"
 	"github.com/omnibuildplatform/omni-repository/common/config"
 	"github.com/omnibuildplatform/omni-repository/common/dtos"
+	"github.com/omnibuildplatform/omni-repository/common/messages"
 	"github.com/omnibuildplatform/omni-repository/common/models"
+	"github.com/omnibuildplatform/omni-repository/common/objectstore"
 	"github.com/omnibuildplatform/omni-repository/common/storage"
+	"github.com/omnibuildplatform/omni-repository/common/workers"
 	"go.uber.org/zap"
 )
 
 const BROWSE_PREFIX = "/browse"
+const MaxChecksumFileSize = 1024 * 1024
+const DefaultUrlExpiry = time.Hour
+
+// AccessTimeResolution limits how often access time of images is written for lru cache eviction.
+const AccessTimeResolution = 10 * time.Minute
+
+// RecentFailureLimit is the number of failed jobs reported by admin api.
+const RecentFailureLimit = 20
 
 type PackageType string
 
@@ -42,10 +57,20 @@ type RepositoryManager struct {
 	config              config.RepoManager
 	paraValidator       *validator.Validate
 	imageDto            *dtos.ImageDTO
+	client              http.Client
+	pipelines           *workers.Pipelines
+	pusherConfig        config.ImagePusher
+	policies            map[string]config.FilePolicy
+	stores              map[string]objectstore.ObjectStore
+	storeLock           sync.Mutex
+	notifier            messages.Notifier
+	imageContexts       *workers.ImageContexts
+	signal              *workers.WorkSignal
+	monitor             *workers.WorkerMonitor
 	Logger              *zap.Logger
 }
 
-func NewRepositoryManager(ctx context.Context, config config.RepoManager, publicRouterGroup *gin.RouterGroup, internalRouterGroup *gin.RouterGroup, imageStore *storage.ImageStorage, baseFolder string, logger *zap.Logger) (*RepositoryManager, error) {
+func NewRepositoryManager(ctx context.Context, config config.RepoManager, workConfig config.WorkManager, publicRouterGroup *gin.RouterGroup, internalRouterGroup *gin.RouterGroup, imageStore *storage.ImageStorage, baseFolder string, logger *zap.Logger, notifier messages.Notifier, imageContexts *workers.ImageContexts, pipelines *workers.Pipelines, signal *workers.WorkSignal, monitor *workers.WorkerMonitor) (*RepositoryManager, error) {
 	if !fsutil.DirExist(baseFolder) {
 		color.Error.Println("data folder %s not existed", baseFolder)
 		return nil, errors.New("data folder not existed")
@@ -59,23 +84,114 @@ func NewRepositoryManager(ctx context.Context, config config.RepoManager, public
 		config:              config,
 		imageDto:            dtos.NewImageDTO(BROWSE_PREFIX),
 		paraValidator:       validator.New(),
+		client:              http.Client{Timeout: 60 * time.Second},
+		pipelines:           pipelines,
+		pusherConfig:        workConfig.Workers.ImagePusher,
+		policies:            workConfig.Policies,
+		stores:              make(map[string]objectstore.ObjectStore),
+		notifier:            notifier,
+		imageContexts:       imageContexts,
+		signal:              signal,
+		monitor:             monitor,
 		Logger:              logger,
 	}, nil
 }
 
 func (r *RepositoryManager) Initialize() error {
 	// register for public routes
-	r.publicRouterGroup.Static(BROWSE_PREFIX, r.dataFolder)
+	r.publicRouterGroup.GET(path.Join(BROWSE_PREFIX, "/*filepath"), r.Browse)
+	r.publicRouterGroup.HEAD(path.Join(BROWSE_PREFIX, "/*filepath"), r.Browse)
 	r.publicRouterGroup.GET("/images/query", r.Query)
+	r.publicRouterGroup.GET("/images/:id/download", r.Download)
 	// register for internal routes
-	r.internalRouterGroup.Static(BROWSE_PREFIX, r.dataFolder)
+	r.internalRouterGroup.GET(path.Join(BROWSE_PREFIX, "/*filepath"), r.Browse)
+	r.internalRouterGroup.HEAD(path.Join(BROWSE_PREFIX, "/*filepath"), r.Browse)
 	r.internalRouterGroup.GET("/images/query", r.Query)
+	r.internalRouterGroup.GET("/images/:id/download", r.Download)
 	r.internalRouterGroup.POST("/images/upload", r.Upload)
 	r.internalRouterGroup.POST("/images/load", r.Load)
 	r.internalRouterGroup.DELETE("/images", r.Delete)
+	r.internalRouterGroup.GET("/images/quarantined", r.ListQuarantined)
+	r.internalRouterGroup.POST("/images/:id/release", r.Release)
+	r.internalRouterGroup.POST("/images/:id/purge", r.Purge)
+	r.internalRouterGroup.POST("/images/:id/verify-remote", r.VerifyRemote)
+	r.internalRouterGroup.POST("/images/:id/unpublish", r.Unpublish)
+	r.internalRouterGroup.POST("/images/:id/publish", r.Publish)
+	r.internalRouterGroup.POST("/images/:id/cancel", r.Cancel)
+	r.internalRouterGroup.POST("/images/:id/retry", r.Retry)
+	r.internalRouterGroup.GET("/jobs", r.ListJobs)
+	r.internalRouterGroup.GET("/admin/workers", r.ListWorkers)
+	r.internalRouterGroup.POST("/admin/workers/pause", r.PauseIntake)
+	r.internalRouterGroup.POST("/admin/workers/resume", r.ResumeIntake)
 	return nil
 }
 
+// Browse serves stored files in the layout of <user-id>/<checksum>/<file>, files of images which are
+// quarantined or not yet scanned are hidden.
+func (r *RepositoryManager) Browse(c *gin.Context) {
+	filePath := path.Clean("/" + c.Param("filepath"))
+	segments := strings.Split(strings.TrimLeft(filePath, "/"), "/")
+	if len(segments) != 3 {
+		c.Data(http.StatusNotFound, "text/plain", []byte("not found"))
+		return
+	}
+	image, err := r.imageStore.GetImageByChecksumAndUserID(segments[0], segments[1])
+	if err != nil || !r.browsable(image) {
+		c.Data(http.StatusNotFound, "text/plain", []byte("not found"))
+		return
+	}
+	if segments[2] == image.FileName {
+		if image.Evicted {
+			//local copy has been evicted, fetch it back from publish target, stop fetching once client is gone
+			err = workers.RehydrateImage(c.Request.Context(), r.pusherConfig, r.imageStore, &image, r.dataFolder, r.Logger)
+			if err != nil {
+				r.Logger.Error(fmt.Sprintf("failed to rehydrate image %d, %v", image.ID, err))
+				c.Data(http.StatusServiceUnavailable, "text/plain", []byte("image temporarily unavailable"))
+				return
+			}
+		} else if image.LastAccessTime == nil || time.Since(*image.LastAccessTime) > AccessTimeResolution {
+			if err = r.imageStore.TouchImage(&image); err != nil {
+				r.Logger.Warn(fmt.Sprintf("failed to record access of image %d, %v", image.ID, err))
+			}
+		}
+	}
+	localFile, err := os.Open(path.Join(r.dataFolder, filePath))
+	if err != nil {
+		c.Data(http.StatusNotFound, "text/plain", []byte("not found"))
+		return
+	}
+	defer localFile.Close()
+	info, err := localFile.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		c.Data(http.StatusNotFound, "text/plain", []byte("not found"))
+		return
+	}
+	if segments[2] == image.FileName {
+		//image file is still being written when its size differs from the recorded one
+		if image.Size != 0 && info.Size() != image.Size {
+			c.Data(http.StatusNotFound, "text/plain", []byte("not found"))
+			return
+		}
+		if len(image.MediaType) != 0 {
+			c.Header("Content-Type", image.MediaType)
+		}
+	}
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), localFile)
+}
+
+// browsable tells whether files of image can be served, only images which passed scan are served, or
+// verified ones when pipeline of image has no scan. Failed, cancelled and corrupted images are never served
+// since they may not have been scanned.
+func (r *RepositoryManager) browsable(image models.Image) bool {
+	switch image.Status {
+	case models.ImageScanned, models.ImagePushing, models.ImagePushed, models.ImageUnpublished:
+		return true
+	case models.ImageVerified:
+		return !r.pipelines.Has(image.ExternalComponent, workers.StageScan)
+	}
+	return false
+}
+
 // @BasePath /images/
 
 // Upload godoc
@@ -90,6 +206,7 @@ func (r *RepositoryManager) Initialize() error {
 func (r *RepositoryManager) Upload(c *gin.Context) {
 
 	var imageRequest dtos.ImageRequestWithinFile
+	startTime := time.Now()
 
 	err := c.MustBindWith(&imageRequest, binding.FormMultipart)
 	if err != nil {
@@ -119,9 +236,25 @@ func (r *RepositoryManager) Upload(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to copy image checksum content into local"})
 		return
 	}
+	targets, err := r.resolveTargets(imageRequest.Publish, imageRequest.Targets)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	image := r.imageDto.GetImageFromRequestWithinFile(imageRequest)
-	//checkSumContent in the format of: "3e7cb72d746c5385b02b7a4bf18360925145d13f06bbd41c1a137e545b651d40 filename"
-	image.Checksum = strings.Split(checkSumContent.String(), " ")[0]
+	image.Publish = len(targets) != 0
+	image.Priority = r.imagePriority(imageRequest.Priority, image.ExternalComponent)
+	//checksum file could be either GNU or BSD tagged format and may contain entries for multiple files
+	entry, err := checksum.ParseFor(checkSumContent.String(), image.FileName)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	image.Checksum, image.Algorithm, err = checksum.Resolve(entry, image.Algorithm)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err := r.validCheckSum(image.Checksum, image.Algorithm); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -144,7 +277,7 @@ func (r *RepositoryManager) Upload(c *gin.Context) {
 		return
 	}
 
-	dstFile, err := os.OpenFile(localFile, os.O_RDWR|os.O_CREATE, 0666)
+	dstFile, err := os.OpenFile(localFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		r.Logger.Error(fmt.Sprintf("failed to create local file for image %v", err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create store file for image"})
@@ -152,24 +285,791 @@ func (r *RepositoryManager) Upload(c *gin.Context) {
 	}
 
 	defer dstFile.Close()
-	if _, err := io.Copy(dstFile, srcFile); err != nil {
+	image.Size, err = io.Copy(dstFile, srcFile)
+	if err != nil {
 		r.Logger.Error(fmt.Sprintf("failed to copy image image %v", err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to copy image content into local"})
 		return
 	}
+	finishTime := time.Now()
+	image.DownloadStartTime = &startTime
+	image.DownloadFinishTime = &finishTime
 	//save image when file saved
 	image.ImagePath = path.Join(GetImageRelativeFolder(&image), image.FileName)
 	image.ChecksumPath = path.Join(GetImageRelativeFolder(&image),
 		fmt.Sprintf("%s.%ssum", image.FileName, strings.ToLower(image.Algorithm)))
 	image.Status = models.ImageDownloaded
-	err = r.imageStore.AddImage(&image)
+	image.Stage = r.pipelines.Entry(image.ExternalComponent, true)
+	err = r.imageStore.AddImageWithReplicas(&image, targets, r.planJobs)
 	if err != nil {
 		r.Logger.Error(fmt.Sprintf("failed to save data into database %v", err))
 		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to save data into database"})
 		return
 	}
+	r.signal.Notify()
 
-	c.JSON(http.StatusCreated, r.imageDto.GenerateResponseFromImage(image))
+	c.JSON(http.StatusCreated, r.generateResponse(image))
+}
+
+// @BasePath /images/
+
+// ListQuarantined godoc
+// @Summary list quarantined images
+// @Param limit query  int	false	"limit"
+// @Description list images which are quarantined by content scanner
+// @Tags Image
+// @Accept json
+// @Produce json
+// @Success 200 array dtos.ImageResponse
+// @Router /quarantined [get]
+func (r *RepositoryManager) ListQuarantined(c *gin.Context) {
+	var listRequest dtos.ListImageRequest
+	if err := c.ShouldBindQuery(&listRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if listRequest.Limit <= 0 {
+		listRequest.Limit = 100
+	}
+	images, err := r.imageStore.GetImagesByStatus(models.ImageQuarantined, listRequest.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	responses := make([]dtos.ImageResponse, 0, len(images))
+	for _, image := range images {
+		responses = append(responses, r.generateResponse(image))
+	}
+	c.JSON(http.StatusOK, responses)
+}
+
+// @BasePath /
+
+// ListJobs godoc
+// @Summary list jobs
+// @Param state query  string	false	"job state"
+// @Param type query  string	false	"work type"
+// @Param imageID query  int	false	"image id"
+// @Param limit query  int	false	"limit"
+// @Description list persistent jobs of image works, latest first
+// @Tags Job
+// @Accept json
+// @Produce json
+// @Success 200 array dtos.JobResponse
+// @Router /jobs [get]
+func (r *RepositoryManager) ListJobs(c *gin.Context) {
+	var listRequest dtos.ListJobRequest
+	if err := c.ShouldBindQuery(&listRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := r.paraValidator.Struct(listRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if listRequest.Limit <= 0 {
+		listRequest.Limit = 100
+	}
+	jobs, err := r.imageStore.GetJobs(models.JobState(listRequest.State), listRequest.Type, listRequest.ImageID, listRequest.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	responses := make([]dtos.JobResponse, 0, len(jobs))
+	for _, job := range jobs {
+		responses = append(responses, dtos.GenerateResponseFromJob(job))
+	}
+	c.JSON(http.StatusOK, responses)
+}
+
+// @BasePath /
+
+// ListWorkers godoc
+// @Summary list worker slots and queues
+// @Description report what each worker slot of this instance is doing, queue depth per work type and latest failed jobs
+// @Tags Worker
+// @Accept json
+// @Produce json
+// @Success 200 object dtos.WorkersResponse
+// @Router /admin/workers [get]
+func (r *RepositoryManager) ListWorkers(c *gin.Context) {
+	pending, err := r.imageStore.CountJobsByType(models.JobPending)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	running, err := r.imageStore.CountJobsByType(models.JobRunning)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	failed, err := r.imageStore.GetRecentFailedJobs(RecentFailureLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	paused, err := r.imageStore.GetPausedWorkTypes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	response := dtos.WorkersResponse{
+		Instance:       r.monitor.Instance(),
+		Workers:        make([]dtos.WorkerSlotResponse, 0),
+		Queues:         make([]dtos.WorkQueueResponse, 0),
+		RecentFailures: make([]dtos.JobResponse, 0, len(failed)),
+	}
+	for _, slot := range r.monitor.Slots() {
+		slotResponse := dtos.WorkerSlotResponse{Slot: slot.Index, Pool: slot.Pool, Status: "idle"}
+		if slot.Busy {
+			startTime := slot.StartTime
+			slotResponse.Status = "busy"
+			slotResponse.ImageID = slot.Work.Image.ID
+			slotResponse.JobID = slot.Work.Job.ID
+			slotResponse.Type = string(slot.Work.Type)
+			//lifecycle works like clean and unpublish are not part of pipelines
+			if stage, ok := r.pipelines.Registry.StageOf(slot.Work.Type); ok {
+				slotResponse.Stage = stage.Name
+			}
+			slotResponse.Target = slot.Work.Replica.Target
+			slotResponse.StartTime = &startTime
+			slotResponse.BytesProcessed = slot.BytesProcessed()
+		}
+		response.Workers = append(response.Workers, slotResponse)
+	}
+	for _, workType := range r.pipelines.Registry.WorkTypes() {
+		response.Queues = append(response.Queues, dtos.WorkQueueResponse{
+			Type:    string(workType),
+			Pending: pending[string(workType)],
+			Running: running[string(workType)],
+			Paused:  paused[string(workType)],
+		})
+	}
+	for _, job := range failed {
+		response.RecentFailures = append(response.RecentFailures, dtos.GenerateResponseFromJob(job))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// @BasePath /
+
+// PauseIntake godoc
+// @Summary pause intake of a work type
+// @Param type query  string	true	"work type"
+// @Description stop claiming jobs of a work type on all instances during maintenance, running works are not affected
+// @Tags Worker
+// @Accept json
+// @Produce json
+// @Success 200 object dtos.WorkersResponse
+// @Router /admin/workers/pause [post]
+func (r *RepositoryManager) PauseIntake(c *gin.Context) {
+	workType, ok := r.getWorkTypeByParam(c)
+	if !ok {
+		return
+	}
+	if err := r.imageStore.SetWorkIntakePaused(string(workType), true); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	r.Logger.Info(fmt.Sprintf("intake of %s paused", workType))
+	r.ListWorkers(c)
+}
+
+// @BasePath /
+
+// ResumeIntake godoc
+// @Summary resume intake of a work type
+// @Param type query  string	true	"work type"
+// @Description claim jobs of a paused work type again
+// @Tags Worker
+// @Accept json
+// @Produce json
+// @Success 200 object dtos.WorkersResponse
+// @Router /admin/workers/resume [post]
+func (r *RepositoryManager) ResumeIntake(c *gin.Context) {
+	workType, ok := r.getWorkTypeByParam(c)
+	if !ok {
+		return
+	}
+	if err := r.imageStore.SetWorkIntakePaused(string(workType), false); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	r.Logger.Info(fmt.Sprintf("intake of %s resumed", workType))
+	//jobs held back while paused are dispatched right away here, other instances pick them up on next sync
+	r.signal.Notify()
+	r.ListWorkers(c)
+}
+
+func (r *RepositoryManager) getWorkTypeByParam(c *gin.Context) (workers.ImageWorkType, bool) {
+	var intakeRequest dtos.WorkerIntakeRequest
+	if err := c.ShouldBindQuery(&intakeRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	if err := r.paraValidator.Struct(intakeRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	for _, workType := range r.pipelines.Registry.WorkTypes() {
+		if string(workType) == intakeRequest.Type {
+			return workType, true
+		}
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown work type %s", intakeRequest.Type)})
+	return "", false
+}
+
+// @BasePath /images/
+
+// Release godoc
+// @Summary release a quarantined image
+// @Param id path  int	true	"image id"
+// @Description release a quarantined image, image will be browsable and pushed afterwards
+// @Tags Image
+// @Accept json
+// @Produce json
+// @Success 200 object dtos.ImageResponse
+// @Router /{id}/release [post]
+func (r *RepositoryManager) Release(c *gin.Context) {
+	image, ok := r.getQuarantinedImage(c)
+	if !ok {
+		return
+	}
+	image.Status = models.ImageScanned
+	image.StatusDetail = "image released from quarantine"
+	if err := r.imageStore.UpdateImageStatusAndDetail(&image); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	r.Logger.Info(fmt.Sprintf("image %d released from quarantine", image.ID))
+	r.scheduleJobs(image)
+	c.JSON(http.StatusOK, r.generateResponse(image))
+}
+
+// @BasePath /images/
+
+// Purge godoc
+// @Summary purge a quarantined image
+// @Param id path  int	true	"image id"
+// @Description delete a quarantined image as well as its files
+// @Tags Image
+// @Accept json
+// @Produce json
+// @Success 200 object dtos.ImageResponse
+// @Router /{id}/purge [post]
+func (r *RepositoryManager) Purge(c *gin.Context) {
+	image, ok := r.getQuarantinedImage(c)
+	if !ok {
+		return
+	}
+	if err := r.imageStore.SoftDeleteImage(&image, string(workers.CleanImageWork)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	r.signal.Notify()
+	r.Logger.Info(fmt.Sprintf("quarantined image %d will be purged", image.ID))
+	c.JSON(http.StatusOK, r.generateResponse(image))
+}
+
+// @BasePath /images/
+
+// VerifyRemote godoc
+// @Summary verify published objects of an image
+// @Param id path  int	true	"image id"
+// @Description queue comparison of size and etag of objects on publish targets with local files, replica is marked failed when differ
+// @Tags Image
+// @Accept json
+// @Produce json
+// @Success 202 object dtos.ImageResponse
+// @Router /{id}/verify-remote [post]
+func (r *RepositoryManager) VerifyRemote(c *gin.Context) {
+	image, ok := r.getImageByParam(c)
+	if !ok {
+		return
+	}
+	if image.Status == models.ImageCorrupted {
+		c.JSON(http.StatusConflict, gin.H{"error": "local copy of image is corrupted, repair it first"})
+		return
+	}
+	requested, err := r.imageStore.RequestReplicaVerification(image.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if requested == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "image has no pushed replica"})
+		return
+	}
+	//objects are hashed by verify works, result shows up in replica status
+	r.scheduleJobs(image)
+	c.JSON(http.StatusAccepted, r.generateResponse(image))
+}
+
+// @BasePath /images/
+
+// Download godoc
+// @Summary download a published image
+// @Param id path  int	true	"image id"
+// @Param target query  string	false	"publish target, any target where image is pushed when empty"
+// @Description redirect to a fresh download url of image on publish target, pre-signed when target is private
+// @Tags Image
+// @Success 302
+// @Router /{id}/download [get]
+func (r *RepositoryManager) Download(c *gin.Context) {
+	image, ok := r.getImageByParam(c)
+	if !ok {
+		return
+	}
+	replicas, err := r.imageStore.GetReplicasByImageID(image.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	target := c.Query("target")
+	_, _, imageKey := workers.ImageObjectKeys(&image)
+	for _, replica := range replicas {
+		if replica.Status != models.ReplicaPushed || (len(target) != 0 && replica.Target != target) {
+			continue
+		}
+		downloadUrl, _, err := r.replicaUrl(image, replica, imageKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.Redirect(http.StatusFound, downloadUrl)
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "image not published"})
+}
+
+// @BasePath /images/
+
+// Publish godoc
+// @Summary publish or re-publish an image
+// @Param id path  int	true	"image id"
+// @Param target query  string	false	"publish target, default targets when empty"
+// @Description push a verified image to publish targets, failed or unpublished replicas are pushed again
+// @Tags Image
+// @Accept json
+// @Produce json
+// @Success 200 object dtos.ImageResponse
+// @Success 202 object dtos.ImageResponse
+// @Router /{id}/publish [post]
+func (r *RepositoryManager) Publish(c *gin.Context) {
+	image, ok := r.getImageByParam(c)
+	if !ok {
+		return
+	}
+	var requested []string
+	if target := c.Query("target"); len(target) != 0 {
+		requested = append(requested, target)
+	}
+	targets, err := r.resolveTargets(true, requested)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	replicas, err := r.imageStore.GetReplicasByImageID(image.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !r.pipelines.Has(image.ExternalComponent, workers.StagePush) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("pipeline of %s has no push stage", image.ExternalComponent)})
+		return
+	}
+	if !r.publishable(image, replicas) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("image can not be published in status %s", image.Status)})
+		return
+	}
+	existing := make(map[string]*models.ImageReplica, len(replicas))
+	for index := range replicas {
+		existing[replicas[index].Target] = &replicas[index]
+	}
+	scheduled := 0
+	for _, target := range targets {
+		replica, ok := existing[target]
+		if !ok {
+			if err := r.imageStore.AddReplica(&models.ImageReplica{ImageID: image.ID, Target: target}); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			scheduled++
+			continue
+		}
+		switch replica.Status {
+		case models.ReplicaPending, models.ReplicaPushing, models.ReplicaPushed:
+			//already published or on the way
+			continue
+		case models.ReplicaRemoteCleanupPending:
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("image is being unpublished from target %s", target)})
+			return
+		}
+		replica.Status = models.ReplicaPending
+		replica.StatusDetail = "waiting for push"
+		replica.Attempts = 0
+		replica.RetryTime = nil
+		if err := r.imageStore.UpdateReplica(replica); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		scheduled++
+	}
+	if scheduled == 0 {
+		c.JSON(http.StatusOK, r.generateResponse(image))
+		return
+	}
+	if !image.Publish {
+		image.Publish = true
+		if err := r.imageStore.UpdateImagePublish(&image); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if image.Status == models.ImageFailed || image.Status == models.ImageUnpublished {
+		//move image back to the status where replicas are picked up for push
+		image.Status = models.ImageVerified
+		if r.pipelines.Has(image.ExternalComponent, workers.StageScan) {
+			image.Status = models.ImageScanned
+		}
+		image.StatusDetail = "waiting for push"
+		if err := r.imageStore.UpdateImageStatusAndDetail(&image); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if image.Stage != workers.StagePush {
+		image.Stage = workers.StagePush
+		if err := r.imageStore.UpdateImagePipelineStage(&image); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	r.Logger.Info(fmt.Sprintf("%d replicas of image %d scheduled for push", scheduled, image.ID))
+	r.scheduleJobs(image)
+	c.JSON(http.StatusAccepted, r.generateResponse(image))
+}
+
+// publishable tells whether image is verified (and scanned when its pipeline scans), images failed in push
+// stage are publishable as well.
+func (r *RepositoryManager) publishable(image models.Image, replicas []models.ImageReplica) bool {
+	switch image.Status {
+	case models.ImageScanned, models.ImagePushing, models.ImagePushed, models.ImageUnpublished:
+		return true
+	case models.ImageVerified:
+		return !r.pipelines.Has(image.ExternalComponent, workers.StageScan)
+	case models.ImageFailed:
+		for _, replica := range replicas {
+			if replica.Status == models.ReplicaFailed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// @BasePath /images/
+
+// Unpublish godoc
+// @Summary unpublish an image
+// @Param id path  int	true	"image id"
+// @Param target query  string	false	"publish target, all targets when empty"
+// @Description remove objects of an image from publish targets in background, local copy is kept. Image is refused while it's being pushed to any of the targets, cancel or wait for the push first
+// @Tags Image
+// @Accept json
+// @Produce json
+// @Success 202 object dtos.ImageResponse
+// @Router /{id}/unpublish [post]
+func (r *RepositoryManager) Unpublish(c *gin.Context) {
+	image, ok := r.getImageByParam(c)
+	if !ok {
+		return
+	}
+	target := c.Query("target")
+	if _, ok := r.pusherConfig.Targets[target]; len(target) != 0 && !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("publish target %s not configured", target)})
+		return
+	}
+	marked, pushing, err := r.imageStore.MarkReplicasForRemoteCleanup(image.ID, target)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if pushing != 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("image is being pushed to %d targets, cancel or wait for the push first", pushing)})
+		return
+	}
+	if marked == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "image has no replica to unpublish"})
+		return
+	}
+	r.Logger.Info(fmt.Sprintf("%d replicas of image %d will be unpublished", marked, image.ID))
+	r.scheduleJobs(image)
+	c.JSON(http.StatusAccepted, r.generateResponse(image))
+}
+
+// @BasePath /images/
+
+// Cancel godoc
+// @Summary cancel works of an image
+// @Param id path  int	true	"image id"
+// @Description stop download, verification, scan or push of an image, temporary files and uploads are cleaned up
+// @Tags Image
+// @Accept json
+// @Produce json
+// @Success 202 object dtos.ImageResponse
+// @Router /{id}/cancel [post]
+func (r *RepositoryManager) Cancel(c *gin.Context) {
+	image, ok := r.getImageByParam(c)
+	if !ok {
+		return
+	}
+	if !workers.Cancellable(image.Status) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("image can not be cancelled in status %s", image.Status)})
+		return
+	}
+	//workers only move images forward from the status they expect, so a work finishing later can't
+	//overwrite the cancellation
+	previous := image.Status
+	image.Status = models.ImageCancelled
+	image.StatusDetail = "image works cancelled by request"
+	cancelled, err := r.imageStore.TransitImageStatus(&image, workers.CancellableStatuses...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("image moved out of status %s meanwhile, retry cancel", previous)})
+		return
+	}
+	//works running on this instance stop right away, others stop on next sync of their instances
+	running := r.imageContexts.Cancel(image.ID)
+	if err := r.imageStore.CancelReplicas(image.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	r.Logger.Info(fmt.Sprintf("image %d cancelled, works running on this instance %t", image.ID, running))
+	c.JSON(http.StatusAccepted, r.generateResponse(image))
+}
+
+// @BasePath /images/
+
+// Retry godoc
+// @Summary retry a failed image
+// @Param id path  int	true	"image id"
+// @Description restart works of a failed image from the stage it failed in, retry attempts are reset
+// @Tags Image
+// @Accept json
+// @Produce json
+// @Success 202 object dtos.ImageResponse
+// @Router /{id}/retry [post]
+func (r *RepositoryManager) Retry(c *gin.Context) {
+	image, ok := r.getImageByParam(c)
+	if !ok {
+		return
+	}
+	if image.Status != models.ImageFailed {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("image can not be retried in status %s", image.Status)})
+		return
+	}
+	replicas, err := r.imageStore.GetReplicasByImageID(image.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	stage := workers.InferFailedStage(image, replicas, r.pipelines)
+	if stage == workers.PullImageWork && len(image.SourceUrl) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "uploaded image can not be downloaded again"})
+		return
+	}
+	if stage == workers.PushImageWork {
+		for index := range replicas {
+			if replicas[index].Status != models.ReplicaFailed {
+				continue
+			}
+			replicas[index].Status = models.ReplicaPending
+			replicas[index].StatusDetail = "waiting for push"
+			replicas[index].Attempts = 0
+			replicas[index].RetryTime = nil
+			if err := r.imageStore.UpdateReplica(&replicas[index]); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+	}
+	image.Status = workers.RestartStatus(stage, r.pipelines.Has(image.ExternalComponent, workers.StageScan))
+	image.StatusDetail = fmt.Sprintf("retry requested from %s", stage)
+	if err := r.imageStore.UpdateImageStatusAndDetail(&image); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if pipelineStage, ok := r.pipelines.Registry.StageOf(stage); ok {
+		image.Stage = pipelineStage.Name
+		if err := r.imageStore.UpdateImagePipelineStage(&image); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	image.FailedStage = ""
+	image.FailureClass = ""
+	image.Attempts = 0
+	image.RetryTime = nil
+	if err := r.imageStore.UpdateImageRetry(&image); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	r.Logger.Info(fmt.Sprintf("image %d will be retried from %s", image.ID, stage))
+	r.scheduleJobs(image)
+	c.JSON(http.StatusAccepted, r.generateResponse(image))
+}
+
+// scheduleJobs queues the works image requires after its status is changed by api, failures are only
+// logged as changes are already saved.
+func (r *RepositoryManager) scheduleJobs(image models.Image) {
+	if err := workers.ScheduleImageJobs(r.imageStore, image.ID, r.pipelines, nil); err != nil {
+		r.Logger.Error(fmt.Sprintf("failed to schedule jobs for image %d, %v", image.ID, err))
+		return
+	}
+	r.signal.Notify()
+}
+
+// planJobs returns the jobs of the entry stage of image which is being created.
+func (r *RepositoryManager) planJobs(image models.Image, replicas []models.ImageReplica) []models.Job {
+	return workers.PlanJobs(image, replicas, r.pipelines)
+}
+
+// imagePriority returns the requested priority, or default priority of component when not requested.
+func (r *RepositoryManager) imagePriority(requested *int, component string) int {
+	if requested != nil {
+		return workers.ClampPriority(*requested)
+	}
+	return workers.ClampPriority(workers.GetComponentPolicy(r.policies, component).Priority)
+}
+
+// resolveTargets validates the requested publish targets, default targets are used when publish is requested
+// without naming any target.
+func (r *RepositoryManager) resolveTargets(publish bool, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		if !publish {
+			return nil, nil
+		}
+		requested = r.pusherConfig.DefaultTargets
+		if len(requested) == 0 {
+			return nil, errors.New("no default publish target configured")
+		}
+	}
+	var targets []string
+	for _, target := range requested {
+		if _, ok := r.pusherConfig.Targets[target]; !ok {
+			return nil, errors.New(fmt.Sprintf("publish target %s not configured", target))
+		}
+		duplicated := false
+		for _, t := range targets {
+			if t == target {
+				duplicated = true
+			}
+		}
+		if !duplicated {
+			targets = append(targets, target)
+		}
+	}
+	return targets, nil
+}
+
+func (r *RepositoryManager) generateResponse(image models.Image) dtos.ImageResponse {
+	replicas, err := r.imageStore.GetReplicasByImageID(image.ID)
+	if err != nil {
+		r.Logger.Error(fmt.Sprintf("failed to get replicas of image %d, %v", image.ID, err))
+	}
+	_, checksumKey, imageKey := workers.ImageObjectKeys(&image)
+	signed := make(map[int]dtos.SignedUrls)
+	for _, replica := range replicas {
+		if replica.Status != models.ReplicaPushed || !r.pusherConfig.Targets[replica.Target].Private {
+			continue
+		}
+		//urls of private targets are signed per request, stored urls are not usable without signature
+		imageUrl, expireTime, err := r.replicaUrl(image, replica, imageKey)
+		if err != nil {
+			r.Logger.Error(fmt.Sprintf("failed to sign url of image %d on target %s, %v", image.ID, replica.Target, err))
+			signed[replica.ID] = dtos.SignedUrls{}
+			continue
+		}
+		checksumUrl, _, err := r.replicaUrl(image, replica, checksumKey)
+		if err != nil {
+			r.Logger.Error(fmt.Sprintf("failed to sign url of image %d on target %s, %v", image.ID, replica.Target, err))
+			checksumUrl = ""
+		}
+		signed[replica.ID] = dtos.SignedUrls{ImageUrl: imageUrl, ChecksumUrl: checksumUrl, ExpireTime: expireTime}
+	}
+	return r.imageDto.GenerateResponseFromImage(image, replicas, signed)
+}
+
+// replicaUrl returns download url of object on replica target, url is pre-signed when the target is private.
+func (r *RepositoryManager) replicaUrl(image models.Image, replica models.ImageReplica, key string) (string, *time.Time, error) {
+	store, err := r.objectStore(replica.Target)
+	if err != nil {
+		return "", nil, err
+	}
+	if !r.pusherConfig.Targets[replica.Target].Private {
+		return store.PublicURL(key), nil, nil
+	}
+	expiry := time.Duration(workers.GetComponentPolicy(r.policies, image.ExternalComponent).UrlExpiry) * time.Second
+	if expiry <= 0 {
+		expiry = DefaultUrlExpiry
+	}
+	expireTime := time.Now().Add(expiry)
+	signedUrl, err := store.PresignGet(r.Context, key, expiry)
+	if err != nil {
+		return "", nil, err
+	}
+	return signedUrl, &expireTime, nil
+}
+
+// objectStore returns object store of publish target, stores are cached since initializing checks the bucket.
+func (r *RepositoryManager) objectStore(target string) (objectstore.ObjectStore, error) {
+	r.storeLock.Lock()
+	defer r.storeLock.Unlock()
+	if store, ok := r.stores[target]; ok {
+		return store, nil
+	}
+	targetConfig, ok := r.pusherConfig.Targets[target]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("publish target %s not configured", target))
+	}
+	store, err := objectstore.NewObjectStore(targetConfig)
+	if err != nil {
+		return nil, err
+	}
+	r.stores[target] = store
+	return store, nil
+}
+
+func (r *RepositoryManager) getImageByParam(c *gin.Context) (models.Image, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid image id %s", c.Param("id"))})
+		return models.Image{}, false
+	}
+	image, err := r.imageStore.GetImageByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found by this id"})
+		return models.Image{}, false
+	}
+	return image, true
+}
+
+func (r *RepositoryManager) getQuarantinedImage(c *gin.Context) (models.Image, bool) {
+	image, ok := r.getImageByParam(c)
+	if !ok {
+		return image, false
+	}
+	if image.Status != models.ImageQuarantined {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("image is not quarantined, current status %s", image.Status)})
+		return image, false
+	}
+	return image, true
 }
 
 func (r *RepositoryManager) StartLoop() {
@@ -208,7 +1108,7 @@ func (r *RepositoryManager) Query(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "image not found by this externalID"})
 		return
 	}
-	c.JSON(http.StatusOK, r.imageDto.GenerateResponseFromImage(item))
+	c.JSON(http.StatusOK, r.generateResponse(item))
 	return
 }
 
@@ -233,6 +1133,35 @@ func (r *RepositoryManager) validCheckSum(checksum string, algorithm string) err
 	return errors.New(fmt.Sprintf("unsupported algorithm %s", algorithm))
 }
 
+func (r *RepositoryManager) fetchChecksum(ctx context.Context, checksumUrl, fileName string) (checksum.Entry, error) {
+	rawUrl, err := url.Parse(checksumUrl)
+	if err != nil {
+		return checksum.Entry{}, err
+	}
+	if rawUrl.Scheme != "http" && rawUrl.Scheme != "https" {
+		return checksum.Entry{}, errors.New(fmt.Sprintf("checksum url schema not supported, %s", rawUrl.Scheme))
+	}
+	request, err := http.NewRequestWithContext(ctx, "GET", checksumUrl, nil)
+	if err != nil {
+		return checksum.Entry{}, errors.New(fmt.Sprintf("failed to construct request for checksum url, %s", checksumUrl))
+	}
+	request.Header.Set("User-Agent", "curl")
+	result, err := r.client.Do(request)
+	if err != nil {
+		return checksum.Entry{}, err
+	}
+	defer result.Body.Close()
+	if result.StatusCode != http.StatusOK {
+		return checksum.Entry{}, errors.New(fmt.Sprintf("unacceptable status code %d when fetching checksum file %s",
+			result.StatusCode, checksumUrl))
+	}
+	content := new(strings.Builder)
+	if _, err := io.Copy(content, io.LimitReader(result.Body, MaxChecksumFileSize)); err != nil {
+		return checksum.Entry{}, err
+	}
+	return checksum.ParseFor(content.String(), fileName)
+}
+
 // @BasePath /images/
 
 // Load godoc
@@ -262,7 +1191,28 @@ func (r *RepositoryManager) Load(c *gin.Context) {
 		return
 	}
 
+	targets, err := r.resolveTargets(imageRequest.Publish, imageRequest.Targets)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"resolveTargets error": err.Error()})
+		return
+	}
 	image := r.imageDto.GetImageFromRequest(imageRequest)
+	image.Publish = len(targets) != 0
+	image.Priority = r.imagePriority(imageRequest.Priority, image.ExternalComponent)
+	if len(image.Checksum) == 0 {
+		entry, err := r.fetchChecksum(c.Request.Context(), imageRequest.ChecksumUrl, image.FileName)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"fetchChecksum error": err.Error()})
+			return
+		}
+		image.Checksum, image.Algorithm, err = checksum.Resolve(entry, image.Algorithm)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"fetchChecksum error": err.Error()})
+			return
+		}
+	} else if len(image.Algorithm) == 0 {
+		image.Algorithm = checksum.InferAlgorithm(image.Checksum)
+	}
 	//TODO: use custom validator instead
 	if err := r.validCheckSum(image.Checksum, image.Algorithm); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"validCheckSum error": err.Error()})
@@ -277,11 +1227,13 @@ func (r *RepositoryManager) Load(c *gin.Context) {
 			existed.FileName)})
 		return
 	}
-	err = r.imageStore.AddImage(&image)
+	image.Stage = r.pipelines.Entry(image.ExternalComponent, false)
+	err = r.imageStore.AddImageWithReplicas(&image, targets, r.planJobs)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"AddImage error": err.Error()})
 		return
 	}
+	r.signal.Notify()
 	c.JSON(http.StatusCreated, image)
 
 }
@@ -318,11 +1270,16 @@ func (r *RepositoryManager) Delete(c *gin.Context) {
 		return
 	}
 
-	err = r.imageStore.SoftDeleteImage(&image)
+	err = r.imageStore.SoftDeleteImage(&image, string(workers.CleanImageWork))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"failed to soft delete image": err.Error()})
 		return
 	}
+	r.signal.Notify()
+	//stop works still writing into the folder clean work removes
+	if r.imageContexts.Cancel(image.ID) {
+		r.Logger.Info(fmt.Sprintf("works of deleted image %d cancelled", image.ID))
+	}
 	c.JSON(http.StatusOK, image)
 
 }
@@ -0,0 +1,145 @@
+package application
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/omnibuildplatform/omni-repository/common/models"
+	"github.com/omnibuildplatform/omni-repository/common/storage"
+	"github.com/omnibuildplatform/omni-repository/common/workers"
+	"go.uber.org/zap"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// fakeDriver answers every query with the image row of the test, statements are accepted without effect.
+type fakeDriver struct {
+	lock  sync.Mutex
+	image models.Image
+}
+
+type fakeConn struct{ driver *fakeDriver }
+type fakeStmt struct{ driver *fakeDriver }
+type fakeTx struct{}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+var (
+	testDriver     = &fakeDriver{}
+	registerDriver sync.Once
+)
+
+func (d *fakeDriver) Open(string) (driver.Conn, error)  { return &fakeConn{driver: d}, nil }
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return &fakeStmt{driver: c.driver}, nil }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+func (fakeTx) Commit() error                            { return nil }
+func (fakeTx) Rollback() error                          { return nil }
+func (s *fakeStmt) Close() error                        { return nil }
+func (s *fakeStmt) NumInput() int                       { return -1 }
+
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	s.driver.lock.Lock()
+	defer s.driver.lock.Unlock()
+	image := s.driver.image
+	return &fakeRows{
+		columns: []string{"id", "user_id", "checksum", "file_name", "status", "external_component", "size"},
+		values: [][]driver.Value{{int64(image.ID), int64(image.UserId), image.Checksum, image.FileName,
+			string(image.Status), image.ExternalComponent, image.Size}},
+	}, nil
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func newBrowseManager(t *testing.T, image models.Image) (*RepositoryManager, string) {
+	registerDriver.Do(func() {
+		sql.Register("fake-browse", testDriver)
+	})
+	testDriver.lock.Lock()
+	testDriver.image = image
+	testDriver.lock.Unlock()
+	sqlDB, err := sql.Open("fake-browse", "")
+	if err != nil {
+		t.Fatalf("failed to open fake database, %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open gorm, %v", err)
+	}
+	pipelines, err := workers.NewPipelines(workers.NewStageRegistry(), nil, true)
+	if err != nil {
+		t.Fatalf("failed to create pipelines, %v", err)
+	}
+	dataFolder := t.TempDir()
+	imageFolder := path.Join(dataFolder, "1", image.Checksum)
+	if err = os.MkdirAll(imageFolder, 0755); err != nil {
+		t.Fatalf("failed to create image folder, %v", err)
+	}
+	if err = os.WriteFile(path.Join(imageFolder, image.FileName), []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to write image file, %v", err)
+	}
+	return &RepositoryManager{
+		Context:    context.Background(),
+		dataFolder: dataFolder,
+		imageStore: storage.NewImageStorage(db, context.Background()),
+		pipelines:  pipelines,
+		Logger:     zap.NewNop(),
+	}, "/1/" + image.Checksum + "/" + image.FileName
+}
+
+func TestBrowse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		name   string
+		status models.ImageStatus
+		code   int
+	}{
+		{name: "scanned", status: models.ImageScanned, code: http.StatusOK},
+		{name: "pushed", status: models.ImagePushed, code: http.StatusOK},
+		{name: "verified but not scanned", status: models.ImageVerified, code: http.StatusNotFound},
+		{name: "failed before scan", status: models.ImageFailed, code: http.StatusNotFound},
+		{name: "cancelled while scanning", status: models.ImageCancelled, code: http.StatusNotFound},
+		{name: "corrupted", status: models.ImageCorrupted, code: http.StatusNotFound},
+		{name: "quarantined", status: models.ImageQuarantined, code: http.StatusNotFound},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			manager, filePath := newBrowseManager(t, models.Image{ID: 1, UserId: 1, Checksum: "abc",
+				FileName: "openEuler.iso", Status: c.status, Size: int64(len("content"))})
+			router := gin.New()
+			router.GET(BROWSE_PREFIX+"/*filepath", manager.Browse)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, BROWSE_PREFIX+filePath, nil))
+			if recorder.Code != c.code {
+				t.Errorf("status code %d, expected %d", recorder.Code, c.code)
+			}
+		})
+	}
+}
@@ -10,69 +10,212 @@ import (
 	"github.com/omnibuildplatform/omni-repository/common/storage"
 	"github.com/omnibuildplatform/omni-repository/common/workers"
 	"go.uber.org/zap"
+	"os"
+	"sync"
 	"time"
 )
 
+// CheckpointTimeout is how long interrupted works are given to checkpoint on shutdown.
+const CheckpointTimeout = 30 * time.Second
+
+// LeaseRenewInterval is how often leases of running jobs are renewed, it must be well below job lease duration.
+const LeaseRenewInterval = 30 * time.Second
+
 type WorkManager struct {
 	Config        config.WorkManager
 	Logger        *zap.Logger
 	ImageStore    *storage.ImageStorage
-	WorkerChannel chan workers.ImageWork
+	Pools         []*workers.WorkPool
 	closeCh       chan struct{}
 	syncWorker    *workers.WorkFetcher
+	inFlight      *workers.InFlightWorks
+	imageContexts *workers.ImageContexts
+	pipelines     *workers.Pipelines
+	signal        *workers.WorkSignal
+	monitor       *workers.WorkerMonitor
 	Context       context.Context
-	baseFolder    string
-	Notifier      messages.Notifier
+	cancel        context.CancelFunc
+	workerGroup   sync.WaitGroup
+	// scrubs, sweeps, evictions and lease renewals, they are waited on close before database is closed
+	backgroundGroup sync.WaitGroup
+	runningLock     sync.Mutex
+	running         map[int]workers.ImageWork
+	interrupted     []string
+	baseFolder      string
+	Notifier        messages.Notifier
 }
 
-func NewWorkManager(ctx context.Context, config config.WorkManager, logger *zap.Logger, imageStore *storage.ImageStorage, baseFolder string, notifier messages.Notifier) (*WorkManager, error) {
+func NewWorkManager(ctx context.Context, config config.WorkManager, logger *zap.Logger, imageStore *storage.ImageStorage, baseFolder string, notifier messages.Notifier, imageContexts *workers.ImageContexts, pipelines *workers.Pipelines, signal *workers.WorkSignal, monitor *workers.WorkerMonitor) (*WorkManager, error) {
+	//works are interrupted on their own context so that database is still reachable when they checkpoint
+	workContext, cancel := context.WithCancel(ctx)
 	workManager := WorkManager{
 		Config:        config,
 		Logger:        logger,
 		ImageStore:    imageStore,
-		WorkerChannel: make(chan workers.ImageWork, config.Threads*4),
+		Pools:         workers.NewWorkPools(config.Pools, config.Threads),
 		closeCh:       make(chan struct{}, 1),
-		Context:       ctx,
+		Context:       workContext,
+		cancel:        cancel,
+		running:       make(map[int]workers.ImageWork),
 		baseFolder:    baseFolder,
 		Notifier:      notifier,
+		inFlight:      workers.NewInFlightWorks(),
+		imageContexts: imageContexts,
+		pipelines:     pipelines,
+		signal:        signal,
+		monitor:       monitor,
+	}
+	workManager.registerWorkers(pipelines.Registry)
+	if err := pipelines.Registry.Validate(); err != nil {
+		cancel()
+		return nil, err
 	}
-	workFetcher, err := workers.NewWorkFetcher(imageStore, logger, workManager.WorkerChannel)
+	instanceID := config.InstanceID
+	if len(instanceID) == 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			cancel()
+			return nil, errors.New(fmt.Sprintf("failed to get instance id from hostname, %v", err))
+		}
+		instanceID = hostname
+	}
+	logger.Info(fmt.Sprintf("work manager claims jobs as instance %s", instanceID))
+	monitor.SetInstance(instanceID)
+	workFetcher, err := workers.NewWorkFetcher(imageStore, logger, workManager.Pools,
+		pipelines, workManager.inFlight,
+		workers.NewJobScheduler(config.Scheduler, config.Policies), config.Retry, signal, instanceID)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 	workManager.syncWorker = workFetcher
+	if err = workers.BackfillReplicas(config.Workers.ImagePusher, imageStore, logger); err != nil {
+		cancel()
+		return nil, err
+	}
 	return &workManager, nil
 }
 
 func (w *WorkManager) GetVerifyingImageWorker(image *models.Image, localFolder string, worker int) (*workers.ImageVerifier, error) {
-	return workers.NewImageVerifier(w.ImageStore, w.Logger, image, localFolder, worker, w.Notifier)
+	return workers.NewImageVerifier(w.ImageStore, w.Logger, image, localFolder, worker,
+		workers.GetComponentPolicy(w.Config.Policies, image.ExternalComponent), w.Notifier)
 }
 
-func (w *WorkManager) GetPushImageWorker(image *models.Image, localFolder string, worker int) (*workers.ImagePusher, error) {
-	return workers.NewImagePusher(w.Config.Workers.ImagePusher, w.ImageStore, image, localFolder, w.Logger, worker, w.Notifier)
+func (w *WorkManager) GetPushImageWorker(image *models.Image, replica *models.ImageReplica, localFolder string, worker int) (*workers.ImagePusher, error) {
+	return workers.NewImagePusher(w.Config.Workers.ImagePusher, w.ImageStore, image, replica, localFolder, w.Logger, worker, w.Notifier)
 }
 
 func (w *WorkManager) GetPullingImageWorker(image *models.Image, localFolder string, worker int) (*workers.ImagePuller, error) {
-	return workers.NewImagePuller(w.Config.Workers.ImagePuller, w.ImageStore, w.Logger, image, localFolder, worker, w.Notifier)
+	return workers.NewImagePuller(w.Config.Workers.ImagePuller, w.ImageStore, w.Logger, image, localFolder, worker,
+		workers.GetComponentPolicy(w.Config.Policies, image.ExternalComponent), w.Notifier)
 }
 
+// Close stops intake of works and waits for running works until shutdown timeout, works still running are
+// interrupted then and checkpointed to be resumed after restart. Jobs of works which never started are
+// returned to queue.
 func (w *WorkManager) Close() {
 	close(w.closeCh)
+	done := make(chan struct{})
+	go func() {
+		w.workerGroup.Wait()
+		close(done)
+	}()
+	timeout := time.Duration(w.Config.ShutdownTimeout) * time.Second
+	w.Logger.Info(fmt.Sprintf("work manager stopped intake, waiting %s for %d running works", timeout, len(w.runningWorks())))
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		for _, work := range w.runningWorks() {
+			w.Logger.Warn(fmt.Sprintf("interrupting %s", describeWork(work)))
+		}
+		w.cancel()
+		select {
+		case <-done:
+		case <-time.After(CheckpointTimeout):
+			w.Logger.Error(fmt.Sprintf("works not checkpointed in %s, they will be resumed from last saved state", CheckpointTimeout))
+		}
+	}
+	w.cancel()
+	//running scrub or eviction stops on cancelled context, none of them writes after database is closed
+	w.backgroundGroup.Wait()
+	requeued := 0
+	for _, pool := range w.Pools {
+		for drained := false; !drained; {
+			select {
+			case work := <-pool.Channel:
+				w.inFlight.Release(work)
+				w.syncWorker.RequeueJob(work.Job, "not started before shutdown", 0)
+				requeued += 1
+			default:
+				drained = true
+			}
+		}
+	}
+	w.runningLock.Lock()
+	defer w.runningLock.Unlock()
+	for _, work := range w.interrupted {
+		w.Logger.Warn(fmt.Sprintf("interrupted by shutdown: %s", work))
+	}
+	w.Logger.Info(fmt.Sprintf("work manager quit, %d works interrupted, %d queued works returned to queue",
+		len(w.interrupted), requeued))
+}
+
+func describeWork(work workers.ImageWork) string {
+	if work.Replica.ID != 0 {
+		return fmt.Sprintf("%s of image %d on target %s (job %d)", work.Type, work.Image.ID, work.Replica.Target, work.Job.ID)
+	}
+	return fmt.Sprintf("%s of image %d (job %d)", work.Type, work.Image.ID, work.Job.ID)
+}
+
+func (w *WorkManager) runningWorks() []workers.ImageWork {
+	w.runningLock.Lock()
+	defer w.runningLock.Unlock()
+	works := make([]workers.ImageWork, 0, len(w.running))
+	for _, work := range w.running {
+		works = append(works, work)
+	}
+	return works
 }
 
 func (w *WorkManager) StartLoop() {
-	for index := 1; index <= w.Config.Threads; index += 1 {
-		go w.PerformImageWorks()
+	for _, pool := range w.Pools {
+		for index := 0; index < pool.Size; index += 1 {
+			w.workerGroup.Add(1)
+			go w.PerformImageWorks(pool, w.monitor.AddSlot(pool.Name))
+		}
+		w.Logger.Info(fmt.Sprintf("started %d workers for %s pool", pool.Size, pool.Name))
+	}
+	if w.Config.Scrubber.Interval > 0 {
+		w.backgroundGroup.Add(1)
+		go w.PerformImageScrubs()
+	}
+	if w.Config.Workers.ImagePusher.SweepInterval > 0 {
+		w.backgroundGroup.Add(1)
+		go w.PerformUploadSweeps()
 	}
+	if w.Config.Cache.Interval > 0 {
+		w.backgroundGroup.Add(1)
+		go w.PerformCacheEvictions()
+	}
+	w.backgroundGroup.Add(1)
+	go w.PerformLeaseRenewals()
 	syncTicker := time.NewTicker(time.Duration(w.Config.SyncInterval) * time.Second)
 	for {
 		select {
 		case <-syncTicker.C:
 			w.Logger.Debug("starting to fetch available works from database")
+			w.cancelWithdrawnWorks()
 			err := w.syncWorker.DoWork(w.Context)
 			if err != nil {
 				w.Logger.Error(fmt.Sprintf("failed to perform database work fetch task, %v", err))
 			}
+		case <-w.signal.C():
+			//jobs queued by this instance are dispatched right away, sync is kept as a safety net
+			w.Logger.Debug("starting to fetch signalled works from database")
+			err := w.syncWorker.DoWork(w.Context)
+			if err != nil {
+				w.Logger.Error(fmt.Sprintf("failed to perform signalled work fetch task, %v", err))
+			}
 		case <-w.closeCh:
 			w.Logger.Info("work manager will quit")
 			return
@@ -80,44 +223,74 @@ func (w *WorkManager) StartLoop() {
 	}
 }
 
-func (w *WorkManager) GetImageWorker(work workers.ImageWork) (workers.Worker, error) {
-	if work.Type == workers.PullImageWork {
+// registerWorkers registers the workers of all image works to stage registry.
+func (w *WorkManager) registerWorkers(registry *workers.StageRegistry) {
+	registry.RegisterWorker(workers.PullImageWork, func(work workers.ImageWork) (workers.Worker, error) {
 		w.Logger.Info(fmt.Sprintf("start to perform image download work for image %d", work.Image.ID))
 		return workers.NewImagePuller(
 			w.Config.Workers.ImagePuller,
 			w.ImageStore, w.Logger, &work.Image,
-			w.baseFolder, w.Config.Threads, w.Notifier)
-	} else if work.Type == workers.PushImageWork {
-		w.Logger.Info(fmt.Sprintf("start to perform image push work for image %d", work.Image.ID))
+			w.baseFolder, workers.PoolOf(w.Pools, workers.PullImageWork).Parallelism,
+			workers.GetComponentPolicy(w.Config.Policies, work.Image.ExternalComponent), w.Notifier)
+	})
+	registry.RegisterWorker(workers.PushImageWork, func(work workers.ImageWork) (workers.Worker, error) {
+		w.Logger.Info(fmt.Sprintf("start to perform image push work for image %d to target %s",
+			work.Image.ID, work.Replica.Target))
 		return workers.NewImagePusher(
 			w.Config.Workers.ImagePusher,
-			w.ImageStore, &work.Image, w.baseFolder,
-			w.Logger, w.Config.Threads, w.Notifier)
-	} else if work.Type == workers.SignImageWork {
+			w.ImageStore, &work.Image, &work.Replica, w.baseFolder,
+			w.Logger, workers.PoolOf(w.Pools, workers.PushImageWork).Parallelism, w.Notifier)
+	})
+	registry.RegisterWorker(workers.VerifyImageWork, func(work workers.ImageWork) (workers.Worker, error) {
 		w.Logger.Info(fmt.Sprintf(
 			"start to perform image verify work for image %d", work.Image.ID))
 		return workers.NewImageVerifier(w.ImageStore, w.Logger,
-			&work.Image, w.baseFolder, w.Config.Threads, w.Notifier)
-	} else if work.Type == workers.CleanImageWork {
+			&work.Image, w.baseFolder, workers.PoolOf(w.Pools, workers.VerifyImageWork).Parallelism,
+			workers.GetComponentPolicy(w.Config.Policies, work.Image.ExternalComponent), w.Notifier)
+	})
+	registry.RegisterWorker(workers.ScanImageWork, func(work workers.ImageWork) (workers.Worker, error) {
+		w.Logger.Info(fmt.Sprintf(
+			"start to perform image scan work for image %d", work.Image.ID))
+		return workers.NewImageScanner(w.Config.Workers.ImageScanner, w.ImageStore, w.Logger,
+			&work.Image, w.baseFolder, w.Notifier)
+	})
+	registry.RegisterWorker(workers.UnpublishImageWork, func(work workers.ImageWork) (workers.Worker, error) {
+		w.Logger.Info(fmt.Sprintf("start to perform image unpublish work for image %d on target %s",
+			work.Image.ID, work.Replica.Target))
+		return workers.NewImageUnpublisher(w.Config.Workers.ImagePusher, w.ImageStore, &work.Image, &work.Replica,
+			w.baseFolder, w.Logger, w.Notifier)
+	})
+	registry.RegisterWorker(workers.VerifyRemoteImageWork, func(work workers.ImageWork) (workers.Worker, error) {
+		w.Logger.Info(fmt.Sprintf("start to perform remote verify work for image %d on target %s",
+			work.Image.ID, work.Replica.Target))
+		return workers.NewImageRemoteVerifier(w.Config.Workers.ImagePusher, w.ImageStore, &work.Image, &work.Replica,
+			w.baseFolder, w.Logger, w.Notifier)
+	})
+	registry.RegisterWorker(workers.CleanImageWork, func(work workers.ImageWork) (workers.Worker, error) {
 		return workers.NewImageCleaner(w.ImageStore, w.Logger, &work.Image, w.baseFolder, w.Notifier)
-	}
-	return nil, errors.New("unsupported image work")
+	})
 }
 
-func (w *WorkManager) PerformImageWorks() {
+func (w *WorkManager) GetImageWorker(work workers.ImageWork) (workers.Worker, error) {
+	return w.pipelines.Registry.NewWorker(work)
+}
+
+// PerformImageWorks runs works queued for pool in worker slot, slot is reported to admin api while work is running.
+func (w *WorkManager) PerformImageWorks(pool *workers.WorkPool, slot int) {
+	defer w.workerGroup.Done()
 	for {
 		select {
-		case work, ok := <-w.WorkerChannel:
+		case work, ok := <-pool.Channel:
 			if ok {
-				worker, err := w.GetImageWorker(work)
-				if err != nil {
-					w.Logger.Error(fmt.Sprintf("failed to get image worker %v", err))
-				} else {
-					err := worker.DoWork(w.Context)
-					if err != nil {
-						w.Logger.Error(fmt.Sprintf("failed to perform image work %v", err))
-					}
+				select {
+				case <-w.closeCh:
+					//intake stopped while both were ready
+					w.inFlight.Release(work)
+					w.syncWorker.RequeueJob(work.Job, "not started before shutdown", 0)
+					return
+				default:
 				}
+				w.performImageWork(slot, work)
 			}
 		case <-w.closeCh:
 			w.Logger.Info("work manager will quit")
@@ -125,3 +298,177 @@ func (w *WorkManager) PerformImageWorks() {
 		}
 	}
 }
+
+// cancelWithdrawnWorks stops works of images which are cancelled or deleted since they started, images
+// could be cancelled through other instances.
+func (w *WorkManager) cancelWithdrawnWorks() {
+	for _, id := range w.imageContexts.ImageIDs() {
+		image, err := w.ImageStore.GetImageByIDIncludingDeleted(id)
+		if err != nil {
+			continue
+		}
+		if image.Deleted || image.Status == models.ImageCancelled {
+			if w.imageContexts.Cancel(id) {
+				w.Logger.Info(fmt.Sprintf("works of image %d cancelled as image is withdrawn", id))
+			}
+		}
+	}
+}
+
+// performImageWork runs work within context of its image and releases its in-flight slot when worker
+// finishes or panics.
+func (w *WorkManager) performImageWork(slot int, work workers.ImageWork) {
+	var err error
+	ctx, release := w.imageContexts.Acquire(w.Context, work.Image.ID)
+	defer release()
+	ctx = w.monitor.Start(ctx, slot, work)
+	defer w.monitor.Finish(slot)
+	w.runningLock.Lock()
+	w.running[work.Job.ID] = work
+	w.runningLock.Unlock()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = errors.New(fmt.Sprintf("image work panicked, %v", recovered))
+			w.Logger.Error(fmt.Sprintf("%s of image %d panicked %v", work.Type, work.Image.ID, recovered))
+		}
+		w.runningLock.Lock()
+		delete(w.running, work.Job.ID)
+		w.runningLock.Unlock()
+		w.inFlight.Release(work)
+		if err != nil && workers.IsInterrupted(ctx) {
+			//worker has checkpointed, job is resumed after restart
+			w.runningLock.Lock()
+			w.interrupted = append(w.interrupted, describeWork(work))
+			w.runningLock.Unlock()
+			w.syncWorker.RequeueJob(work.Job, "interrupted by shutdown", 0)
+			return
+		}
+		var retryLater *workers.RetryLaterError
+		if errors.As(err, &retryLater) {
+			w.syncWorker.RequeueJob(work.Job, err.Error(), retryLater.Delay)
+			return
+		}
+		w.syncWorker.CompleteJob(work.Job, err)
+	}()
+	worker, err := w.GetImageWorker(work)
+	if err != nil {
+		w.Logger.Error(fmt.Sprintf("failed to get image worker %v", err))
+		return
+	}
+	err = worker.DoWork(ctx)
+	if err != nil {
+		w.Logger.Error(fmt.Sprintf("failed to perform image work %v", err))
+	}
+}
+
+// PerformImageScrubs re-hashes stored images periodically, images are scrubbed one by one so that
+// the I/O budget of scrubber is honored.
+func (w *WorkManager) PerformImageScrubs() {
+	defer w.backgroundGroup.Done()
+	scrubTicker := time.NewTicker(time.Duration(w.Config.Scrubber.Interval) * time.Second)
+	defer scrubTicker.Stop()
+	batchSize := w.Config.Scrubber.BatchSize
+	if batchSize <= 0 {
+		batchSize = 10
+	}
+	for {
+		select {
+		case <-scrubTicker.C:
+			before := time.Now().Add(-time.Duration(w.Config.Scrubber.MinAge) * time.Second)
+			images, err := w.ImageStore.GetImageForScrub(before, batchSize)
+			if err != nil {
+				w.Logger.Error(fmt.Sprintf("failed to fetch images for scrub, %v", err))
+				continue
+			}
+			if len(images) != 0 {
+				w.Logger.Info(fmt.Sprintf("found %d images for scrub", len(images)))
+			}
+			for index := range images {
+				scrubber, err := workers.NewImageScrubber(w.Config.Scrubber, w.Config.Workers.ImagePusher, w.ImageStore, w.Logger,
+					&images[index], w.baseFolder, w.Notifier)
+				if err != nil {
+					w.Logger.Error(fmt.Sprintf("failed to get image scrubber %v", err))
+					continue
+				}
+				if err := scrubber.DoWork(w.Context); err != nil {
+					w.Logger.Error(fmt.Sprintf("failed to scrub image %d %v", images[index].ID, err))
+				}
+				scrubber.Close()
+				//repaired images may continue the works skipped while they were corrupted
+				if err := workers.ScheduleImageJobs(w.ImageStore, images[index].ID, w.pipelines, nil); err != nil {
+					w.Logger.Error(fmt.Sprintf("failed to schedule jobs for image %d %v", images[index].ID, err))
+					continue
+				}
+				w.signal.Notify()
+			}
+		case <-w.closeCh:
+			w.Logger.Info("image scrubber will quit")
+			return
+		}
+	}
+}
+
+// PerformLeaseRenewals renews leases of jobs claimed by this instance until work manager quits, so that
+// other instances don't take them over.
+func (w *WorkManager) PerformLeaseRenewals() {
+	defer w.backgroundGroup.Done()
+	renewTicker := time.NewTicker(LeaseRenewInterval)
+	defer renewTicker.Stop()
+	for {
+		select {
+		case <-renewTicker.C:
+			w.syncWorker.RenewLeases()
+		case <-w.closeCh:
+			w.Logger.Info("lease renewal will quit")
+			return
+		}
+	}
+}
+
+// PerformUploadSweeps aborts stale incomplete multipart uploads on publish targets periodically.
+func (w *WorkManager) PerformUploadSweeps() {
+	defer w.backgroundGroup.Done()
+	sweepTicker := time.NewTicker(time.Duration(w.Config.Workers.ImagePusher.SweepInterval) * time.Second)
+	defer sweepTicker.Stop()
+	for {
+		select {
+		case <-sweepTicker.C:
+			sweeper, err := workers.NewUploadSweeper(w.Config.Workers.ImagePusher, w.ImageStore, w.Logger)
+			if err != nil {
+				w.Logger.Error(fmt.Sprintf("failed to get upload sweeper %v", err))
+				continue
+			}
+			if err := sweeper.DoWork(w.Context); err != nil {
+				w.Logger.Error(fmt.Sprintf("failed to sweep stale uploads %v", err))
+			}
+			sweeper.Close()
+		case <-w.closeCh:
+			w.Logger.Info("upload sweeper will quit")
+			return
+		}
+	}
+}
+
+// PerformCacheEvictions evicts local copies of published images periodically according to cache policy.
+func (w *WorkManager) PerformCacheEvictions() {
+	defer w.backgroundGroup.Done()
+	evictTicker := time.NewTicker(time.Duration(w.Config.Cache.Interval) * time.Second)
+	defer evictTicker.Stop()
+	for {
+		select {
+		case <-evictTicker.C:
+			evictor, err := workers.NewCacheEvictor(w.Config.Cache, w.ImageStore, w.Logger, w.baseFolder)
+			if err != nil {
+				w.Logger.Error(fmt.Sprintf("failed to get cache evictor %v", err))
+				continue
+			}
+			if err := evictor.DoWork(w.Context); err != nil {
+				w.Logger.Error(fmt.Sprintf("failed to evict cached images %v", err))
+			}
+			evictor.Close()
+		case <-w.closeCh:
+			w.Logger.Info("cache evictor will quit")
+			return
+		}
+	}
+}
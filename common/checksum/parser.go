@@ -0,0 +1,132 @@
+package checksum
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	AlgorithmMD5    = "md5"
+	AlgorithmSHA1   = "sha1"
+	AlgorithmSHA256 = "sha256"
+	AlgorithmSHA512 = "sha512"
+)
+
+var (
+	//BSD tagged format: "SHA256 (file.iso) = <hex>", openssl prints "SHA256(file.iso)= <hex>"
+	bsdPattern = regexp.MustCompile(`^([A-Za-z0-9-]+)\s*\((.*)\)\s*=\s*([a-fA-F0-9]+)$`)
+	//GNU coreutils format: "<hex>  file.iso", "<hex> *file.iso" or a bare "<hex>"
+	gnuPattern = regexp.MustCompile(`^\\?([a-fA-F0-9]+)(?:\s+[* ]?(.*))?$`)
+)
+
+type Entry struct {
+	Algorithm string
+	Checksum  string
+	FileName  string
+}
+
+// InferAlgorithm guesses the digest algorithm by the length of hex checksum, empty string returned when unknown.
+func InferAlgorithm(checksum string) string {
+	switch len(checksum) {
+	case 32:
+		return AlgorithmMD5
+	case 40:
+		return AlgorithmSHA1
+	case 64:
+		return AlgorithmSHA256
+	case 128:
+		return AlgorithmSHA512
+	}
+	return ""
+}
+
+// Parse collects all checksum entries in content, lines which are not recognized (comments, PGP armor and
+// so on) are skipped.
+func Parse(content string) []Entry {
+	var entries []Entry
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(strings.TrimSuffix(line, "\r"))
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if matches := bsdPattern.FindStringSubmatch(line); matches != nil {
+			entries = append(entries, Entry{
+				Algorithm: normalizeAlgorithm(matches[1]),
+				FileName:  normalizeFileName(matches[2]),
+				Checksum:  strings.ToLower(matches[3]),
+			})
+			continue
+		}
+		if matches := gnuPattern.FindStringSubmatch(line); matches != nil {
+			if InferAlgorithm(matches[1]) == "" {
+				continue
+			}
+			entries = append(entries, Entry{
+				Algorithm: InferAlgorithm(matches[1]),
+				FileName:  normalizeFileName(matches[2]),
+				Checksum:  strings.ToLower(matches[1]),
+			})
+		}
+	}
+	return entries
+}
+
+// ParseFor finds the checksum entry for fileName in content, a single bare checksum without file name is
+// accepted as well.
+func ParseFor(content, fileName string) (Entry, error) {
+	entries := Parse(content)
+	if len(entries) == 0 {
+		return Entry{}, errors.New("no checksum entry found in checksum file")
+	}
+	for _, e := range entries {
+		if e.FileName == path.Base(fileName) {
+			return e, nil
+		}
+	}
+	if len(entries) == 1 {
+		if len(entries[0].FileName) == 0 {
+			return entries[0], nil
+		}
+		return Entry{}, errors.New(fmt.Sprintf("checksum file records file %s instead of %s",
+			entries[0].FileName, path.Base(fileName)))
+	}
+	return Entry{}, errors.New(fmt.Sprintf("checksum file contains %d entries but none for file %s",
+		len(entries), fileName))
+}
+
+// Resolve returns the checksum and algorithm of entry, algorithm is the one requested by user, it will be
+// inferred from the entry when empty.
+func Resolve(entry Entry, algorithm string) (string, string, error) {
+	algorithm = strings.ToLower(algorithm)
+	if len(algorithm) == 0 {
+		algorithm = entry.Algorithm
+	}
+	if len(algorithm) == 0 {
+		return "", "", errors.New(fmt.Sprintf("unable to infer algorithm for checksum %s", entry.Checksum))
+	}
+	if len(entry.Algorithm) != 0 && entry.Algorithm != algorithm {
+		return "", "", errors.New(fmt.Sprintf("checksum file provides %s checksum while %s requested",
+			entry.Algorithm, algorithm))
+	}
+	return entry.Checksum, algorithm, nil
+}
+
+// normalizeAlgorithm maps tags like "SHA256", "SHA-256" and "SHA2-256" of openssl 3 to algorithm names.
+func normalizeAlgorithm(tag string) string {
+	tag = strings.ToLower(tag)
+	if strings.HasPrefix(tag, "sha2-") {
+		tag = "sha" + strings.TrimPrefix(tag, "sha2-")
+	}
+	return strings.ReplaceAll(tag, "-", "")
+}
+
+func normalizeFileName(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		return ""
+	}
+	return path.Base(strings.TrimPrefix(name, "*"))
+}
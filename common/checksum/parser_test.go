@@ -0,0 +1,115 @@
+package checksum
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	md5Sum    = "d41d8cd98f00b204e9800998ecf8427e"
+	sha256Sum = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	otherSum  = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		entries []Entry
+	}{
+		{
+			name:    "gnu",
+			content: sha256Sum + "  openEuler.iso\n",
+			entries: []Entry{{Algorithm: AlgorithmSHA256, Checksum: sha256Sum, FileName: "openEuler.iso"}},
+		},
+		{
+			name:    "gnu binary marker",
+			content: sha256Sum + " *openEuler.iso\n",
+			entries: []Entry{{Algorithm: AlgorithmSHA256, Checksum: sha256Sum, FileName: "openEuler.iso"}},
+		},
+		{
+			name:    "gnu bare checksum",
+			content: strings.ToUpper(md5Sum),
+			entries: []Entry{{Algorithm: AlgorithmMD5, Checksum: md5Sum}},
+		},
+		{
+			name:    "bsd",
+			content: "SHA256 (openEuler.iso) = " + sha256Sum + "\n",
+			entries: []Entry{{Algorithm: AlgorithmSHA256, Checksum: sha256Sum, FileName: "openEuler.iso"}},
+		},
+		{
+			name:    "openssl",
+			content: "SHA2-256(dir/openEuler.iso)= " + sha256Sum,
+			entries: []Entry{{Algorithm: AlgorithmSHA256, Checksum: sha256Sum, FileName: "openEuler.iso"}},
+		},
+		{
+			name:    "crlf",
+			content: sha256Sum + "  openEuler.iso\r\n" + otherSum + "  openEuler.qcow2\r\n",
+			entries: []Entry{
+				{Algorithm: AlgorithmSHA256, Checksum: sha256Sum, FileName: "openEuler.iso"},
+				{Algorithm: AlgorithmSHA256, Checksum: otherSum, FileName: "openEuler.qcow2"},
+			},
+		},
+		{
+			name: "multi entry with comments and armor",
+			content: "-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA256\n\n# openEuler images\n" +
+				sha256Sum + "  openEuler.iso\n" + "SHA256 (openEuler.qcow2) = " + otherSum + "\n" +
+				"-----BEGIN PGP SIGNATURE-----\n",
+			entries: []Entry{
+				{Algorithm: AlgorithmSHA256, Checksum: sha256Sum, FileName: "openEuler.iso"},
+				{Algorithm: AlgorithmSHA256, Checksum: otherSum, FileName: "openEuler.qcow2"},
+			},
+		},
+		{
+			name:    "unknown checksum length",
+			content: "abcdef  openEuler.iso\n",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if entries := Parse(c.content); !reflect.DeepEqual(entries, c.entries) {
+				t.Errorf("entries %+v, expected %+v", entries, c.entries)
+			}
+		})
+	}
+}
+
+func TestParseFor(t *testing.T) {
+	multiEntry := sha256Sum + "  openEuler.iso\n" + otherSum + " *openEuler.qcow2\n"
+	cases := []struct {
+		name     string
+		content  string
+		fileName string
+		checksum string
+		fails    bool
+	}{
+		{name: "gnu", content: sha256Sum + "  openEuler.iso\n", fileName: "openEuler.iso", checksum: sha256Sum},
+		{name: "bsd", content: "SHA256 (openEuler.iso) = " + sha256Sum, fileName: "openEuler.iso", checksum: sha256Sum},
+		{name: "binary marker", content: otherSum + " *openEuler.qcow2", fileName: "openEuler.qcow2", checksum: otherSum},
+		{name: "crlf", content: strings.ReplaceAll(multiEntry, "\n", "\r\n"), fileName: "openEuler.qcow2", checksum: otherSum},
+		{name: "multi entry", content: multiEntry, fileName: "openEuler.qcow2", checksum: otherSum},
+		{name: "multi entry matched by base name", content: multiEntry, fileName: "images/openEuler.iso", checksum: sha256Sum},
+		{name: "multi entry without match", content: multiEntry, fileName: "openEuler.raw", fails: true},
+		{name: "single entry naming a different file", content: sha256Sum + "  upstream.iso\n", fileName: "openEuler.iso", fails: true},
+		{name: "single bare checksum", content: sha256Sum + "\n", fileName: "openEuler.iso", checksum: sha256Sum},
+		{name: "no entry", content: "# nothing here\n", fileName: "openEuler.iso", fails: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			entry, err := ParseFor(c.content, c.fileName)
+			if c.fails {
+				if err == nil {
+					t.Fatalf("expected error, got entry %+v", entry)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if entry.Checksum != c.checksum {
+				t.Errorf("checksum %s, expected %s", entry.Checksum, c.checksum)
+			}
+		})
+	}
+}
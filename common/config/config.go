@@ -26,9 +26,60 @@ type (
 	}
 
 	WorkManager struct {
-		SyncInterval int     `mapstructure:"syncInterval"`
-		Threads      int     `mapstructure:"threads"`
-		Workers      Workers `mapstructure:"workers"`
+		SyncInterval    int                   `mapstructure:"syncInterval"`
+		Threads         int                   `mapstructure:"threads"`
+		ShutdownTimeout int                   `mapstructure:"shutdownTimeout"`
+		InstanceID      string                `mapstructure:"instanceID"`
+		Workers         Workers               `mapstructure:"workers"`
+		Scrubber        Scrubber              `mapstructure:"scrubber"`
+		Cache           Cache                 `mapstructure:"cache"`
+		Scheduler       Scheduler             `mapstructure:"scheduler"`
+		Retry           Retry                 `mapstructure:"retry"`
+		Pipelines       map[string][]string   `mapstructure:"pipelines"`
+		Pools           Pools                 `mapstructure:"pools"`
+		Policies        map[string]FilePolicy `mapstructure:"policies"`
+	}
+
+	Cache struct {
+		Policy           string `mapstructure:"policy"`
+		Interval         int    `mapstructure:"interval"`
+		MaxSize          int64  `mapstructure:"maxSize"`
+		HighWaterPercent int    `mapstructure:"highWaterPercent"`
+	}
+
+	Scheduler struct {
+		AgingInterval int `mapstructure:"agingInterval"`
+	}
+
+	Pools struct {
+		Pull              int `mapstructure:"pull"`
+		Verify            int `mapstructure:"verify"`
+		Push              int `mapstructure:"push"`
+		Clean             int `mapstructure:"clean"`
+		PullParallelism   int `mapstructure:"pullParallelism"`
+		VerifyParallelism int `mapstructure:"verifyParallelism"`
+		PushParallelism   int `mapstructure:"pushParallelism"`
+	}
+
+	Retry struct {
+		MaxAttempts int      `mapstructure:"maxAttempts"`
+		Interval    int      `mapstructure:"interval"`
+		Classes     []string `mapstructure:"classes"`
+	}
+
+	FilePolicy struct {
+		AllowedTypes []string `mapstructure:"allowedTypes"`
+		MaxSize      int64    `mapstructure:"maxSize"`
+		UrlExpiry    int      `mapstructure:"urlExpiry"`
+		Priority     int      `mapstructure:"priority"`
+		Weight       int      `mapstructure:"weight"`
+	}
+
+	Scrubber struct {
+		Interval       int   `mapstructure:"interval"`
+		MinAge         int   `mapstructure:"minAge"`
+		BatchSize      int   `mapstructure:"batchSize"`
+		BytesPerSecond int64 `mapstructure:"bytesPerSecond"`
 	}
 
 	PersistentStore struct {
@@ -43,6 +94,7 @@ type (
 		ImagePusher   ImagePusher   `mapstructure:"imagerPusher"`
 		ImageVerifier ImageVerifier `mapstructure:"imageVerifier"`
 		ImagePuller   ImagePuller   `mapstructure:"imagerPuller"`
+		ImageScanner  ImageScanner  `mapstructure:"imageScanner"`
 	}
 
 	ImagePuller struct {
@@ -53,12 +105,46 @@ type (
 	ImageVerifier struct {
 	}
 
+	ImageScanner struct {
+		Type      string `mapstructure:"type"`
+		Network   string `mapstructure:"network"`
+		Address   string `mapstructure:"address"`
+		Timeout   int    `mapstructure:"timeout"`
+		ChunkSize int    `mapstructure:"chunkSize"`
+	}
+
 	ImagePusher struct {
+		PartSize          int64                    `mapstructure:"partSize"`
+		MaxRetry          int                      `mapstructure:"maxRetry"`
+		RetryInterval     int                      `mapstructure:"retryInterval"`
+		PartMaxRetry      int                      `mapstructure:"partMaxRetry"`
+		PartRetryInterval int                      `mapstructure:"partRetryInterval"`
+		DefaultTargets    []string                 `mapstructure:"defaultTargets"`
+		Targets           map[string]PublishTarget `mapstructure:"targets"`
+		SweepInterval     int                      `mapstructure:"sweepInterval"`
+		StaleUploadAge    int                      `mapstructure:"staleUploadAge"`
+		// deprecated keys of the single obs bucket, they are used as an obs target when no target is configured
 		Endpoint string `mapstructure:"endpoint"`
 		AK       string `mapstructure:"ak"`
 		SK       string `mapstructure:"sk"`
 		Bucket   string `mapstructure:"bucket"`
-		PartSize int64  `mapstructure:"partSize"`
+	}
+
+	PublishTarget struct {
+		Type      string `mapstructure:"type"`
+		Endpoint  string `mapstructure:"endpoint"`
+		Region    string `mapstructure:"region"`
+		PathStyle bool   `mapstructure:"pathStyle"`
+		Insecure  bool   `mapstructure:"insecure"`
+		AK        string `mapstructure:"ak"`
+		SK        string `mapstructure:"sk"`
+		Bucket    string `mapstructure:"bucket"`
+		// objects of private bucket are served by pre-signed urls
+		Private bool `mapstructure:"private"`
+		// used by local store only
+		RootFolder string `mapstructure:"rootFolder"`
+		// base url of published objects, defaults to the bucket endpoint
+		PublicBaseUrl string `mapstructure:"publicBaseUrl"`
 	}
 
 	MQ struct {
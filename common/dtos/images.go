@@ -10,26 +10,31 @@ import (
 )
 
 type ImageRequest struct {
-	Name              string `description:"name"  form:"name" json:"name" validate:"required"`
-	Desc              string `description:"desc"  form:"desc" json:"desc"`
-	Checksum          string `description:"checksum" form:"checksum" json:"checksum" validate:"required"`
-	Algorithm         string `description:"algorithm" form:"algorithm" json:"algorithm" validate:"required,oneof=md5 sha256"`
-	ExternalID        string `description:"externalID" form:"externalID" json:"externalID" validate:"required"`
-	SourceUrl         string `description:"source url of images" json:"sourceUrl" form:"sourceUrl" validate:"required"`
-	FileName          string `description:"file name" form:"fileName" json:"fileName" validate:"required"`
-	UserId            int    `description:"user id" form:"userID" json:"userID" validate:"required"`
-	Publish           bool   `description:"publish image to third party storage" form:"publish" json:"publish"  `
-	ExternalComponent string `description:"From APP" form:"externalComponent" json:"externalComponent" validate:"required"`
+	Name              string   `description:"name"  form:"name" json:"name" validate:"required"`
+	Desc              string   `description:"desc"  form:"desc" json:"desc"`
+	Checksum          string   `description:"checksum" form:"checksum" json:"checksum" validate:"required_without=ChecksumUrl"`
+	ChecksumUrl       string   `description:"url of checksum file, used when checksum is empty" form:"checksumUrl" json:"checksumUrl,omitempty"`
+	Algorithm         string   `description:"algorithm, inferred from checksum when empty" form:"algorithm" json:"algorithm" validate:"omitempty,oneof=md5 sha256"`
+	ExternalID        string   `description:"externalID" form:"externalID" json:"externalID" validate:"required"`
+	SourceUrl         string   `description:"source url of images" json:"sourceUrl" form:"sourceUrl" validate:"required"`
+	FileName          string   `description:"file name" form:"fileName" json:"fileName" validate:"required"`
+	UserId            int      `description:"user id" form:"userID" json:"userID" validate:"required"`
+	Publish           bool     `description:"publish image to third party storage" form:"publish" json:"publish"  `
+	Targets           []string `description:"publish targets, default targets used when publish without targets" form:"targets" json:"targets"`
+	Priority          *int     `description:"priority of image works from 0 to 9, component default used when empty" form:"priority" json:"priority,omitempty" validate:"omitempty,min=0,max=9"`
+	ExternalComponent string   `description:"From APP" form:"externalComponent" json:"externalComponent" validate:"required"`
 }
 
 type ImageRequestWithinFile struct {
 	Name              string                `description:"name"  form:"name" json:"name" validate:"required"`
 	Desc              string                `description:"desc"  form:"desc" json:"desc"`
-	Algorithm         string                `description:"algorithm" form:"algorithm" json:"algorithm" validate:"required,oneof=md5 sha256"`
+	Algorithm         string                `description:"algorithm, inferred from checksum file when empty" form:"algorithm" json:"algorithm" validate:"omitempty,oneof=md5 sha256"`
 	ExternalID        string                `description:"externalID" form:"externalID" json:"externalID" validate:"required"`
 	FileName          string                `description:"file name" form:"fileName" json:"fileName" validate:"required"`
 	UserId            int                   `description:"user id" form:"userID" json:"userID" validate:"required"`
 	Publish           bool                  `description:"publish image to third party storage" form:"publish" json:"publish"  `
+	Targets           []string              `description:"publish targets, default targets used when publish without targets" form:"targets" json:"targets"`
+	Priority          *int                  `description:"priority of image works from 0 to 9, component default used when empty" form:"priority" json:"priority,omitempty" validate:"omitempty,min=0,max=9"`
 	ExternalComponent string                `description:"From APP" form:"externalComponent" json:"externalComponent" validate:"required"`
 	CheckSumFile      *multipart.FileHeader `form:"checksumFile" binding:"required" swaggerignore:"true"`
 	ImageFile         *multipart.FileHeader `form:"imageFile" binding:"required" swaggerignore:"true"`
@@ -37,19 +42,62 @@ type ImageRequestWithinFile struct {
 
 type ImageResponse struct {
 	ImageRequest
-	ID           int                `description:"id" form:"id" json:"id"`
-	Status       models.ImageStatus `description:"image status" json:"status"`
-	StatusDetail string             `description:"status detail"  json:"statusDetail"`
-	ImagePath    string             `description:"image store path"  json:"imagePath"`
-	ChecksumPath string             `description:"image checksum store path"  json:"checksumPath"`
-	CreateTime   time.Time          `description:"create time" json:"createTime"`
-	UpdateTime   time.Time          `description:"update time" json:"updateTime"`
+	ID                 int                `description:"id" form:"id" json:"id"`
+	Status             models.ImageStatus `description:"image status" json:"status"`
+	StatusDetail       string             `description:"status detail"  json:"statusDetail"`
+	Stage              string             `description:"current stage in pipeline of image, done when all stages passed" json:"stage"`
+	ImagePath          string             `description:"image store path"  json:"imagePath"`
+	ChecksumPath       string             `description:"image checksum store path"  json:"checksumPath"`
+	CreateTime         time.Time          `description:"create time" json:"createTime"`
+	UpdateTime         time.Time          `description:"update time" json:"updateTime"`
+	Size               int64              `description:"image size in bytes" json:"size"`
+	MediaType          string             `description:"media type detected from file content" json:"mediaType"`
+	DownloadStartTime  *time.Time         `description:"time when image started downloading or uploading" json:"downloadStartTime,omitempty"`
+	DownloadFinishTime *time.Time         `description:"time when image finished downloading or uploading" json:"downloadFinishTime,omitempty"`
+	VerifyStartTime    *time.Time         `description:"time when image started verifying" json:"verifyStartTime,omitempty"`
+	VerifyFinishTime   *time.Time         `description:"time when image finished verifying" json:"verifyFinishTime,omitempty"`
+	PushStartTime      *time.Time         `description:"time when image started pushing" json:"pushStartTime,omitempty"`
+	PushFinishTime     *time.Time         `description:"time when image finished pushing" json:"pushFinishTime,omitempty"`
+	LastScrubTime      *time.Time         `description:"last time local file was re-hashed" json:"lastScrubTime,omitempty"`
+	LastScrubResult    string             `description:"result of last scrub" json:"lastScrubResult,omitempty"`
+	Evicted            bool               `description:"whether local copy has been evicted" json:"evicted"`
+	FailedStage        string             `description:"work type of the stage image failed in" json:"failedStage,omitempty"`
+	FailureClass       string             `description:"class of the failure" json:"failureClass,omitempty"`
+	Attempts           int                `description:"retries of failed stage" json:"attempts"`
+	RetryTime          *time.Time         `description:"time when failed stage will be retried automatically" json:"retryTime,omitempty"`
+	Replicas           []ReplicaResponse  `description:"image replicas on publish targets" json:"replicas"`
+}
+
+type ReplicaResponse struct {
+	Target        string               `description:"publish target" json:"target"`
+	Status        models.ReplicaStatus `description:"replica status" json:"status"`
+	StatusDetail  string               `description:"status detail" json:"statusDetail"`
+	ImagePath     string               `description:"image url on publish target" json:"imagePath"`
+	ChecksumPath  string               `description:"image checksum url on publish target" json:"checksumPath"`
+	Attempts      int                  `description:"push attempts" json:"attempts"`
+	TotalBytes    int64                `description:"bytes of image to push" json:"totalBytes"`
+	UploadedBytes int64                `description:"bytes of image pushed" json:"uploadedBytes"`
+	Throughput    int64                `description:"push throughput in bytes per second" json:"throughput"`
+	ExpireTime    *time.Time           `description:"expire time of pre-signed urls" json:"expireTime,omitempty"`
+	// set while requested verification of objects on target is pending
+	VerifyRequestTime *time.Time `description:"time when pending verification was requested" json:"verifyRequestTime,omitempty"`
+}
+
+// SignedUrls are pre-signed download urls of replica on private target.
+type SignedUrls struct {
+	ImageUrl    string
+	ChecksumUrl string
+	ExpireTime  *time.Time
 }
 
 type QueryImageRequest struct {
 	ExternalID string `form:"externalID" json:"externalID" validate:"required"`
 }
 
+type ListImageRequest struct {
+	Limit int `form:"limit" json:"limit"`
+}
+
 type DeleteImageRequest struct {
 	UserID   string `form:"userID" json:"userID" validate:"required"`
 	Checksum string `form:"checksum" json:"checksum" validate:"required"`
@@ -93,7 +141,10 @@ func (i *ImageDTO) GetImageFromRequestWithinFile(imageRequest ImageRequestWithin
 	}
 }
 
-func (i *ImageDTO) GenerateResponseFromImage(image models.Image) ImageResponse {
+// GenerateResponseFromImage converts image and its replicas, urls of replicas found in signed by replica id
+// replace the stored ones.
+func (i *ImageDTO) GenerateResponseFromImage(image models.Image, replicas []models.ImageReplica, signed map[int]SignedUrls) ImageResponse {
+	priority := image.Priority
 	imageResponse := ImageResponse{
 		ImageRequest: ImageRequest{
 			Name:              image.Name,
@@ -105,20 +156,61 @@ func (i *ImageDTO) GenerateResponseFromImage(image models.Image) ImageResponse {
 			FileName:          image.FileName,
 			UserId:            image.UserId,
 			Publish:           image.Publish,
+			Priority:          &priority,
 			ExternalComponent: image.ExternalComponent,
 		},
-		ID:           image.ID,
-		Status:       image.Status,
-		StatusDetail: image.StatusDetail,
-		CreateTime:   image.CreateTime,
-		UpdateTime:   image.UpdateTime,
+		ID:                 image.ID,
+		Status:             image.Status,
+		StatusDetail:       image.StatusDetail,
+		Stage:              image.Stage,
+		CreateTime:         image.CreateTime,
+		UpdateTime:         image.UpdateTime,
+		Size:               image.Size,
+		MediaType:          image.MediaType,
+		DownloadStartTime:  image.DownloadStartTime,
+		DownloadFinishTime: image.DownloadFinishTime,
+		VerifyStartTime:    image.VerifyStartTime,
+		VerifyFinishTime:   image.VerifyFinishTime,
+		PushStartTime:      image.PushStartTime,
+		PushFinishTime:     image.PushFinishTime,
+		LastScrubTime:      image.LastScrubTime,
+		LastScrubResult:    image.LastScrubResult,
+		Evicted:            image.Evicted,
+		Attempts:           image.Attempts,
 	}
-	if imageResponse.Status != models.ImagePushed {
+	if image.Status == models.ImageFailed {
+		imageResponse.FailedStage = image.FailedStage
+		imageResponse.FailureClass = image.FailureClass
+		imageResponse.RetryTime = image.RetryTime
+	}
+	//image path of images pushed by earlier versions were rewritten into external url
+	if !strings.HasPrefix(image.ImagePath, "http") {
 		imageResponse.ImagePath = fmt.Sprintf("%s/%s", strings.TrimRight(i.browsePrefix, "/"), strings.TrimLeft(image.ImagePath, "/"))
 		imageResponse.ChecksumPath = fmt.Sprintf("%s/%s", strings.TrimRight(i.browsePrefix, "/"), strings.TrimLeft(image.ChecksumPath, "/"))
 	} else {
 		imageResponse.ImagePath = image.ImagePath
 		imageResponse.ChecksumPath = image.ChecksumPath
 	}
+	imageResponse.Replicas = make([]ReplicaResponse, 0, len(replicas))
+	for _, replica := range replicas {
+		replicaResponse := ReplicaResponse{
+			Target:            replica.Target,
+			Status:            replica.Status,
+			StatusDetail:      replica.StatusDetail,
+			ImagePath:         replica.ImageUrl,
+			ChecksumPath:      replica.ChecksumUrl,
+			Attempts:          replica.Attempts,
+			TotalBytes:        replica.TotalBytes,
+			UploadedBytes:     replica.UploadedBytes,
+			Throughput:        replica.Throughput,
+			VerifyRequestTime: replica.VerifyRequestTime,
+		}
+		if urls, ok := signed[replica.ID]; ok {
+			replicaResponse.ImagePath = urls.ImageUrl
+			replicaResponse.ChecksumPath = urls.ChecksumUrl
+			replicaResponse.ExpireTime = urls.ExpireTime
+		}
+		imageResponse.Replicas = append(imageResponse.Replicas, replicaResponse)
+	}
 	return imageResponse
 }
@@ -0,0 +1,56 @@
+package dtos
+
+import (
+	"time"
+
+	"github.com/omnibuildplatform/omni-repository/common/models"
+)
+
+type ListJobRequest struct {
+	State   string `form:"state" json:"state" validate:"omitempty,oneof=JobPending JobRunning JobSucceeded JobFailed JobSkipped"`
+	Type    string `form:"type" json:"type"`
+	ImageID int    `form:"imageID" json:"imageID"`
+	Limit   int    `form:"limit" json:"limit"`
+}
+
+type JobResponse struct {
+	ID                int             `description:"id" json:"id"`
+	Type              string          `description:"work type" json:"type"`
+	ImageID           int             `description:"image id" json:"imageID"`
+	ReplicaID         int             `description:"replica id" json:"replicaID,omitempty"`
+	UserID            int             `description:"user id of image" json:"userID"`
+	Component         string          `description:"external component of image" json:"component"`
+	Priority          int             `description:"priority of job" json:"priority"`
+	State             models.JobState `description:"job state" json:"state"`
+	Attempts          int             `description:"times the job has been claimed" json:"attempts"`
+	RunAfter          time.Time       `description:"time before which job won't be claimed" json:"runAfter"`
+	LastError         string          `description:"error of last run" json:"lastError,omitempty"`
+	EffectivePriority int             `description:"priority including aging when job was claimed" json:"effectivePriority"`
+	ScheduleNote      string          `description:"why job was picked" json:"scheduleNote,omitempty"`
+	Owner             string          `description:"instance which claimed the job" json:"owner,omitempty"`
+	LeaseExpireTime   *time.Time      `description:"time after which running job is taken over by other instances" json:"leaseExpireTime,omitempty"`
+	CreateTime        time.Time       `description:"create time" json:"createTime"`
+	UpdateTime        time.Time       `description:"update time" json:"updateTime"`
+}
+
+func GenerateResponseFromJob(job models.Job) JobResponse {
+	return JobResponse{
+		ID:                job.ID,
+		Type:              job.Type,
+		ImageID:           job.ImageID,
+		ReplicaID:         job.ReplicaID,
+		UserID:            job.UserID,
+		Component:         job.Component,
+		Priority:          job.Priority,
+		State:             job.State,
+		Attempts:          job.Attempts,
+		RunAfter:          job.RunAfter,
+		LastError:         job.LastError,
+		EffectivePriority: job.EffectivePriority,
+		ScheduleNote:      job.ScheduleNote,
+		Owner:             job.Owner,
+		LeaseExpireTime:   job.LeaseExpireTime,
+		CreateTime:        job.CreateTime,
+		UpdateTime:        job.UpdateTime,
+	}
+}
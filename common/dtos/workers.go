@@ -0,0 +1,36 @@
+package dtos
+
+import (
+	"time"
+)
+
+type WorkerIntakeRequest struct {
+	Type string `form:"type" json:"type" validate:"required"`
+}
+
+type WorkerSlotResponse struct {
+	Slot           int        `description:"index of worker slot" json:"slot"`
+	Pool           string     `description:"pool of worker slot" json:"pool"`
+	Status         string     `description:"idle or busy" json:"status"`
+	ImageID        int        `description:"image id of running work" json:"imageID,omitempty"`
+	JobID          int        `description:"job id of running work" json:"jobID,omitempty"`
+	Type           string     `description:"work type of running work" json:"type,omitempty"`
+	Stage          string     `description:"pipeline stage of running work" json:"stage,omitempty"`
+	Target         string     `description:"publish target of push or unpublish work" json:"target,omitempty"`
+	StartTime      *time.Time `description:"time when running work started" json:"startTime,omitempty"`
+	BytesProcessed int64      `description:"bytes downloaded, hashed or uploaded by running work" json:"bytesProcessed"`
+}
+
+type WorkQueueResponse struct {
+	Type    string `description:"work type" json:"type"`
+	Pending int64  `description:"jobs waiting in queue, including delayed ones" json:"pending"`
+	Running int64  `description:"jobs claimed by instances" json:"running"`
+	Paused  bool   `description:"whether intake of work type is paused on all instances" json:"paused"`
+}
+
+type WorkersResponse struct {
+	Instance       string               `description:"instance which served the request, worker slots are of this instance" json:"instance"`
+	Workers        []WorkerSlotResponse `description:"worker slots of this instance" json:"workers"`
+	Queues         []WorkQueueResponse  `description:"queue depth per work type" json:"queues"`
+	RecentFailures []JobResponse        `description:"latest failed jobs" json:"recentFailures"`
+}
@@ -0,0 +1,87 @@
+package filetype
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+)
+
+const (
+	MediaTypeISO     = "application/x-iso9660-image"
+	MediaTypeQcow2   = "application/x-qemu-disk"
+	MediaTypeVMDK    = "application/x-vmdk"
+	MediaTypeVHDX    = "application/x-vhdx"
+	MediaTypeRawDisk = "application/x-raw-disk-image"
+	MediaTypeTar     = "application/x-tar"
+	MediaTypeGzip    = "application/gzip"
+	MediaTypeXz      = "application/x-xz"
+	MediaTypeZstd    = "application/zstd"
+	MediaTypeBzip2   = "application/x-bzip2"
+	MediaTypeZip     = "application/zip"
+	MediaTypeRPM     = "application/x-rpm"
+	MediaTypeUnknown = "application/octet-stream"
+)
+
+// sniffLength covers the ISO 9660 volume descriptors which start at sector 16.
+const sniffLength = 0x9006
+
+type signature struct {
+	offset    int
+	magic     []byte
+	mediaType string
+}
+
+// signatures are checked in order, ISO comes first since hybrid ISO images also carry a MBR.
+var signatures = []signature{
+	{offset: 0x8001, magic: []byte("CD001"), mediaType: MediaTypeISO},
+	{offset: 0x8801, magic: []byte("CD001"), mediaType: MediaTypeISO},
+	{offset: 0x9001, magic: []byte("CD001"), mediaType: MediaTypeISO},
+	{offset: 0, magic: []byte("QFI\xfb"), mediaType: MediaTypeQcow2},
+	{offset: 0, magic: []byte("KDMV"), mediaType: MediaTypeVMDK},
+	{offset: 0, magic: []byte("vhdxfile"), mediaType: MediaTypeVHDX},
+	{offset: 0, magic: []byte("\xed\xab\xee\xdb"), mediaType: MediaTypeRPM},
+	{offset: 0, magic: []byte("\x1f\x8b"), mediaType: MediaTypeGzip},
+	{offset: 0, magic: []byte("\xfd7zXZ\x00"), mediaType: MediaTypeXz},
+	{offset: 0, magic: []byte("\x28\xb5\x2f\xfd"), mediaType: MediaTypeZstd},
+	{offset: 0, magic: []byte("BZh"), mediaType: MediaTypeBzip2},
+	{offset: 0, magic: []byte("PK\x03\x04"), mediaType: MediaTypeZip},
+	{offset: 257, magic: []byte("ustar"), mediaType: MediaTypeTar},
+	{offset: 512, magic: []byte("EFI PART"), mediaType: MediaTypeRawDisk},
+	{offset: 510, magic: []byte("\x55\xaa"), mediaType: MediaTypeRawDisk},
+}
+
+// Detect sniffs the media type of content by its magic bytes, http.DetectContentType is used as fallback.
+func Detect(reader io.ReaderAt) (string, error) {
+	header := make([]byte, sniffLength)
+	n, err := reader.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+	for _, s := range signatures {
+		if len(header) >= s.offset+len(s.magic) && bytes.Equal(header[s.offset:s.offset+len(s.magic)], s.magic) {
+			return s.mediaType, nil
+		}
+	}
+	if len(header) == 0 {
+		return MediaTypeUnknown, nil
+	}
+	//parameters such as charset are not part of the media type stored with image
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(header))
+	if err != nil {
+		return MediaTypeUnknown, nil
+	}
+	return mediaType, nil
+}
+
+// DetectFile sniffs the media type of the file located at filePath.
+func DetectFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return Detect(f)
+}
@@ -0,0 +1,54 @@
+package filetype
+
+import (
+	"bytes"
+	"testing"
+)
+
+// withMagic returns content of size bytes carrying magic at offset.
+func withMagic(size, offset int, magic string) []byte {
+	content := make([]byte, size)
+	copy(content[offset:], magic)
+	return content
+}
+
+func TestDetect(t *testing.T) {
+	hybridISO := withMagic(sniffLength, 0x8001, "CD001")
+	copy(hybridISO[510:], "\x55\xaa")
+	cases := []struct {
+		name      string
+		content   []byte
+		mediaType string
+	}{
+		{name: "iso primary descriptor", content: withMagic(sniffLength, 0x8001, "CD001"), mediaType: MediaTypeISO},
+		{name: "iso second descriptor", content: withMagic(sniffLength, 0x8801, "CD001"), mediaType: MediaTypeISO},
+		{name: "iso third descriptor", content: withMagic(sniffLength, 0x9001, "CD001"), mediaType: MediaTypeISO},
+		{name: "hybrid iso with mbr", content: hybridISO, mediaType: MediaTypeISO},
+		{name: "qcow2", content: withMagic(1024, 0, "QFI\xfb"), mediaType: MediaTypeQcow2},
+		{name: "vmdk", content: withMagic(1024, 0, "KDMV"), mediaType: MediaTypeVMDK},
+		{name: "vhdx", content: withMagic(1024, 0, "vhdxfile"), mediaType: MediaTypeVHDX},
+		{name: "rpm", content: withMagic(1024, 0, "\xed\xab\xee\xdb"), mediaType: MediaTypeRPM},
+		{name: "gzip", content: withMagic(1024, 0, "\x1f\x8b"), mediaType: MediaTypeGzip},
+		{name: "xz", content: withMagic(1024, 0, "\xfd7zXZ\x00"), mediaType: MediaTypeXz},
+		{name: "zstd", content: withMagic(1024, 0, "\x28\xb5\x2f\xfd"), mediaType: MediaTypeZstd},
+		{name: "bzip2", content: withMagic(1024, 0, "BZh"), mediaType: MediaTypeBzip2},
+		{name: "zip", content: withMagic(1024, 0, "PK\x03\x04"), mediaType: MediaTypeZip},
+		{name: "tar", content: withMagic(1024, 257, "ustar"), mediaType: MediaTypeTar},
+		{name: "gpt disk", content: withMagic(1024, 512, "EFI PART"), mediaType: MediaTypeRawDisk},
+		{name: "mbr disk", content: withMagic(1024, 510, "\x55\xaa"), mediaType: MediaTypeRawDisk},
+		{name: "magic cut off", content: []byte("ust"), mediaType: "text/plain"},
+		{name: "text fallback without charset", content: []byte("plain text content\n"), mediaType: "text/plain"},
+		{name: "empty", content: []byte{}, mediaType: MediaTypeUnknown},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mediaType, err := Detect(bytes.NewReader(c.content))
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if mediaType != c.mediaType {
+				t.Errorf("media type %s, expected %s", mediaType, c.mediaType)
+			}
+		})
+	}
+}
@@ -10,41 +10,71 @@ const (
 	ImageDownloaded  ImageStatus = "ImageDownloaded"
 	ImageVerifying   ImageStatus = "ImageVerifying"
 	ImageVerified    ImageStatus = "ImageVerified"
+	ImageScanning    ImageStatus = "ImageScanning"
+	ImageScanned     ImageStatus = "ImageScanned"
+	ImageQuarantined ImageStatus = "ImageQuarantined"
 	ImagePushing     ImageStatus = "ImagePushing"
 	ImagePushed      ImageStatus = "ImagePushed"
 	ImageFailed      ImageStatus = "ImageFailed"
+	ImageCorrupted   ImageStatus = "ImageCorrupted"
+	ImageUnpublished ImageStatus = "ImageUnpublished"
+	ImageCancelled   ImageStatus = "ImageCancelled"
 )
 
 type ImageEventType string
 
 const (
-	ImageEventCreated    ImageEventType = "obp.omni_repository.image.created"
-	ImageEventDownloaded ImageEventType = "obp.omni_repository.image.downloaded"
-	ImageEventVerified   ImageEventType = "obp.omni_repository.image.verified"
-	ImageEventPushed     ImageEventType = "obp.omni_repository.image.pushed"
-	ImageEventFailed     ImageEventType = "obp.omni_repository.image.failed"
-	ImageEventCleaned    ImageEventType = "obp.omni_repository.image.cleaned"
+	ImageEventCreated     ImageEventType = "obp.omni_repository.image.created"
+	ImageEventDownloaded  ImageEventType = "obp.omni_repository.image.downloaded"
+	ImageEventVerified    ImageEventType = "obp.omni_repository.image.verified"
+	ImageEventScanned     ImageEventType = "obp.omni_repository.image.scanned"
+	ImageEventQuarantined ImageEventType = "obp.omni_repository.image.quarantined"
+	ImageEventPushed      ImageEventType = "obp.omni_repository.image.pushed"
+	ImageEventFailed      ImageEventType = "obp.omni_repository.image.failed"
+	ImageEventCleaned     ImageEventType = "obp.omni_repository.image.cleaned"
+	ImageEventCorrupted   ImageEventType = "obp.omni_repository.image.corrupted"
+	ImageEventRepaired    ImageEventType = "obp.omni_repository.image.repaired"
+	ImageEventUnpublished ImageEventType = "obp.omni_repository.image.unpublished"
+	ImageEventCancelled   ImageEventType = "obp.omni_repository.image.cancelled"
 )
 
 type Image struct {
-	ID                int         `description:"id" gorm:"primaryKey"`
-	Name              string      `description:"name"`
-	Desc              string      `description:"desc"`
-	Checksum          string      `description:"checksum"`
-	Algorithm         string      `description:"algorithm" gorm:"sha256"`
-	ExternalID        string      `description:"externalID"`
-	SourceUrl         string      `description:"source url of images"`
-	FileName          string      `description:"file name"`
-	UserId            int         `description:"user id"`
-	Status            ImageStatus `description:"image status"`
-	StatusDetail      string      `description:"status detail"`
-	ImagePath         string      `description:"image store path"`
-	ChecksumPath      string      `description:"image checksum store path"`
-	CreateTime        time.Time   `description:"create time"`
-	UpdateTime        time.Time   `description:"update time"`
-	Publish           bool        `description:"publish image to third party storage"`
-	ExternalComponent string      `description:"eg. omni-manager , ....."`
-	Deleted           bool        `description:"whether image has been deleted"`
+	ID                 int         `description:"id" gorm:"primaryKey"`
+	Name               string      `description:"name"`
+	Desc               string      `description:"desc"`
+	Checksum           string      `description:"checksum"`
+	Algorithm          string      `description:"algorithm" gorm:"sha256"`
+	ExternalID         string      `description:"externalID"`
+	SourceUrl          string      `description:"source url of images"`
+	FileName           string      `description:"file name"`
+	UserId             int         `description:"user id"`
+	Status             ImageStatus `description:"image status"`
+	StatusDetail       string      `description:"status detail"`
+	ImagePath          string      `description:"image store path"`
+	ChecksumPath       string      `description:"image checksum store path"`
+	CreateTime         time.Time   `description:"create time"`
+	UpdateTime         time.Time   `description:"update time"`
+	Publish            bool        `description:"publish image to third party storage"`
+	ExternalComponent  string      `description:"eg. omni-manager , ....."`
+	Deleted            bool        `description:"whether image has been deleted"`
+	Size               int64       `description:"image size in bytes"`
+	MediaType          string      `description:"media type detected from file content"`
+	DownloadStartTime  *time.Time  `description:"time when image started downloading or uploading"`
+	DownloadFinishTime *time.Time  `description:"time when image finished downloading or uploading"`
+	VerifyStartTime    *time.Time  `description:"time when image started verifying"`
+	VerifyFinishTime   *time.Time  `description:"time when image finished verifying"`
+	PushStartTime      *time.Time  `description:"time when image started pushing"`
+	PushFinishTime     *time.Time  `description:"time when image finished pushing"`
+	LastScrubTime      *time.Time  `description:"last time local file was re-hashed"`
+	LastScrubResult    string      `description:"result of last scrub"`
+	Evicted            bool        `description:"whether local copy has been evicted, image is re-fetched from publish target on demand"`
+	LastAccessTime     *time.Time  `description:"time when local copy was last accessed"`
+	Priority           int         `description:"scheduling priority of image works, higher first"`
+	Stage              string      `description:"current stage of image in the pipeline of its component"`
+	FailedStage        string      `description:"work type of the stage image failed in"`
+	FailureClass       string      `description:"class of the failure, decides whether it's retried automatically"`
+	Attempts           int         `description:"retries of failed stage"`
+	RetryTime          *time.Time  `description:"time when failed stage will be retried automatically"`
 }
 
 func (Image) TableName() string {
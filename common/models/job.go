@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+type JobState string
+
+const (
+	JobPending   JobState = "JobPending"
+	JobRunning   JobState = "JobRunning"
+	JobSucceeded JobState = "JobSucceeded"
+	JobFailed    JobState = "JobFailed"
+	// image changed after job was queued and the work is no longer required
+	JobSkipped JobState = "JobSkipped"
+)
+
+// Job is a persistent unit of image work, jobs are claimed by work manager when run after time is due.
+type Job struct {
+	ID                int        `description:"id" gorm:"primaryKey"`
+	Type              string     `description:"work type"`
+	ImageID           int        `description:"image id" gorm:"index"`
+	ReplicaID         int        `description:"replica id, only used by replica works"`
+	UserID            int        `description:"user id of image, used by fair scheduling"`
+	Component         string     `description:"external component of image, used by fair scheduling"`
+	Priority          int        `description:"priority of image when job was queued"`
+	State             JobState   `description:"job state" gorm:"index:idx_jobs_dequeue,priority:1"`
+	Attempts          int        `description:"times the job has been claimed"`
+	RunAfter          time.Time  `description:"time before which job won't be claimed" gorm:"index:idx_jobs_dequeue,priority:2"`
+	LastError         string     `description:"error of last run" gorm:"type:text"`
+	EffectivePriority int        `description:"priority including aging when job was claimed"`
+	ScheduleNote      string     `description:"why job was picked"`
+	Owner             string     `description:"instance which claimed the job"`
+	LeaseExpireTime   *time.Time `description:"time after which running job is considered abandoned by its owner"`
+	ActiveKey         *string    `description:"type, image and replica of pending or running job, empty once finished" gorm:"uniqueIndex;size:191"`
+	CreateTime        time.Time  `description:"create time"`
+	UpdateTime        time.Time  `description:"update time"`
+}
+
+func (Job) TableName() string {
+	return "jobs"
+}
+
+// WorkIntake records whether jobs of a work type are claimed, it's shared by all instances.
+type WorkIntake struct {
+	Type       string    `description:"work type" gorm:"primaryKey"`
+	Paused     bool      `description:"whether instances stop claiming jobs of work type"`
+	UpdateTime time.Time `description:"update time"`
+}
+
+func (WorkIntake) TableName() string {
+	return "work_intakes"
+}
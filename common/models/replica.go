@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+type ReplicaStatus string
+
+const (
+	ReplicaPending ReplicaStatus = "ReplicaPending"
+	ReplicaPushing ReplicaStatus = "ReplicaPushing"
+	ReplicaPushed  ReplicaStatus = "ReplicaPushed"
+	ReplicaFailed  ReplicaStatus = "ReplicaFailed"
+	// objects on target are being removed, failed removal will be retried
+	ReplicaRemoteCleanupPending ReplicaStatus = "ReplicaRemoteCleanupPending"
+	ReplicaUnpublished          ReplicaStatus = "ReplicaUnpublished"
+)
+
+// ImageReplica records the publish state of an image on one publish target.
+type ImageReplica struct {
+	ID            int           `description:"id" gorm:"primaryKey"`
+	ImageID       int           `description:"image id" gorm:"index"`
+	Target        string        `description:"publish target name"`
+	Status        ReplicaStatus `description:"replica status"`
+	StatusDetail  string        `description:"status detail"`
+	ImageUrl      string        `description:"image url on publish target"`
+	ChecksumUrl   string        `description:"image checksum url on publish target"`
+	Attempts      int           `description:"push attempts"`
+	RetryTime     *time.Time    `description:"time when failed push will be retried, empty when no more retry"`
+	UploadKey     string        `description:"object key of in-progress multipart upload"`
+	UploadID      string        `description:"id of in-progress multipart upload"`
+	UploadParts   string        `description:"json encoded parts completed in multipart upload" gorm:"type:text"`
+	TotalBytes    int64         `description:"bytes of image to push"`
+	UploadedBytes int64         `description:"bytes of image pushed"`
+	Throughput    int64         `description:"push throughput in bytes per second"`
+	// verification is planned while it's set, cleared once objects on target are verified
+	VerifyRequestTime *time.Time `description:"time when verification of objects on target was requested"`
+	CreateTime        time.Time  `description:"create time"`
+	UpdateTime        time.Time  `description:"update time"`
+}
+
+func (ImageReplica) TableName() string {
+	return "image_replicas"
+}
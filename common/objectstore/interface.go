@@ -0,0 +1,75 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/omnibuildplatform/omni-repository/common/config"
+)
+
+const (
+	OBSStoreType   = "obs"
+	S3StoreType    = "s3"
+	LocalStoreType = "local"
+)
+
+type (
+	ObjectInfo struct {
+		Key  string
+		Size int64
+		ETag string
+	}
+
+	Part struct {
+		PartNumber int
+		ETag       string
+		Size       int64
+	}
+
+	// MultipartUpload is an initiated but not yet completed or aborted multipart upload.
+	MultipartUpload struct {
+		Key       string
+		UploadID  string
+		Initiated time.Time
+	}
+
+	// ObjectStore is the publish target of images, objects larger than part size are uploaded in multipart.
+	ObjectStore interface {
+		// Stat returns the object information, os.ErrNotExist returned when object not found.
+		Stat(ctx context.Context, key string) (ObjectInfo, error)
+		Put(ctx context.Context, key string, reader io.Reader, size int64) error
+		// Get returns the content of object, os.ErrNotExist returned when object not found.
+		Get(ctx context.Context, key string) (io.ReadCloser, error)
+		InitiateMultipart(ctx context.Context, key string) (string, error)
+		UploadPart(ctx context.Context, key, uploadID string, partNumber int, localPath string, offset, size int64) (Part, error)
+		CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error
+		// ListParts returns the parts already uploaded, os.ErrNotExist returned when upload not found.
+		ListParts(ctx context.Context, key, uploadID string) ([]Part, error)
+		AbortMultipart(ctx context.Context, key, uploadID string) error
+		ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error)
+		Delete(ctx context.Context, key string) error
+		PublicURL(key string) string
+		// PresignGet returns a time-limited download url of object, used when bucket is private.
+		PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error)
+	}
+)
+
+func NewObjectStore(config config.PublishTarget) (ObjectStore, error) {
+	switch config.Type {
+	case OBSStoreType, "":
+		return NewOBSStore(config)
+	case S3StoreType:
+		return NewS3Store(config)
+	case LocalStoreType:
+		return NewLocalStore(config)
+	}
+	return nil, errors.New(fmt.Sprintf("unsupported object store type %s", config.Type))
+}
+
+func joinURL(baseUrl, key string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(baseUrl, "/"), strings.TrimLeft(key, "/"))
+}
@@ -0,0 +1,262 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gookit/goutil/fsutil"
+	"github.com/omnibuildplatform/omni-repository/common/config"
+)
+
+const LocalUploadFolder = ".uploads"
+const LocalUploadKeyFile = "key"
+
+// LocalStore publishes objects into a local directory tree, e.g. a NFS mounted mirror root or nginx docroot,
+// objects are written into temporary files first and then renamed into place.
+type LocalStore struct {
+	config config.PublishTarget
+}
+
+func NewLocalStore(config config.PublishTarget) (ObjectStore, error) {
+	if len(config.RootFolder) == 0 {
+		return nil, errors.New("incorrect root folder config for local store")
+	}
+	if !fsutil.DirExist(config.RootFolder) {
+		return nil, errors.New(fmt.Sprintf("root folder %s not existed", config.RootFolder))
+	}
+	if len(config.PublicBaseUrl) == 0 {
+		return nil, errors.New("incorrect public base url config for local store")
+	}
+	return &LocalStore{
+		config: config,
+	}, nil
+}
+
+func (l *LocalStore) objectPath(key string) string {
+	return filepath.Join(l.config.RootFolder, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+func (l *LocalStore) uploadFolder(uploadID string) string {
+	return filepath.Join(l.config.RootFolder, LocalUploadFolder, filepath.Base(uploadID))
+}
+
+func (l *LocalStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := os.Stat(l.objectPath(key))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Key:  key,
+		Size: info.Size(),
+	}, nil
+}
+
+func (l *LocalStore) Put(ctx context.Context, key string, reader io.Reader, size int64) error {
+	//folder object
+	if strings.HasSuffix(key, "/") {
+		return os.MkdirAll(l.objectPath(key), fsutil.DefaultDirPerm)
+	}
+	_, err := l.writeAtomically(l.objectPath(key), func(w io.Writer) error {
+		_, err := io.Copy(w, reader)
+		return err
+	})
+	return err
+}
+
+func (l *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(l.objectPath(key))
+}
+
+func (l *LocalStore) InitiateMultipart(ctx context.Context, key string) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(random)
+	if err := os.MkdirAll(l.uploadFolder(uploadID), fsutil.DefaultDirPerm); err != nil {
+		return "", err
+	}
+	//key is recorded so that incomplete uploads can be listed
+	if err := ioutil.WriteFile(filepath.Join(l.uploadFolder(uploadID), LocalUploadKeyFile), []byte(key), 0644); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+func (l *LocalStore) UploadPart(ctx context.Context, key, uploadID string, partNumber int, localPath string, offset, size int64) (Part, error) {
+	source, err := os.Open(localPath)
+	if err != nil {
+		return Part{}, err
+	}
+	defer source.Close()
+	etag, err := l.writeAtomically(filepath.Join(l.uploadFolder(uploadID), strconv.Itoa(partNumber)), func(w io.Writer) error {
+		_, err := io.Copy(w, io.NewSectionReader(source, offset, size))
+		return err
+	})
+	if err != nil {
+		return Part{}, err
+	}
+	return Part{PartNumber: partNumber, ETag: etag, Size: size}, nil
+}
+
+func (l *LocalStore) CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error {
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	objectPath := l.objectPath(key)
+	if err := os.MkdirAll(filepath.Dir(objectPath), fsutil.DefaultDirPerm); err != nil {
+		return err
+	}
+	_, err := l.writeAtomically(objectPath, func(w io.Writer) error {
+		for _, p := range parts {
+			partFile, err := os.Open(filepath.Join(l.uploadFolder(uploadID), strconv.Itoa(p.PartNumber)))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, partFile)
+			partFile.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(l.uploadFolder(uploadID))
+}
+
+func (l *LocalStore) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	files, err := ioutil.ReadDir(l.uploadFolder(uploadID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	var parts []Part
+	for _, f := range files {
+		partNumber, err := strconv.Atoi(f.Name())
+		if err != nil || f.IsDir() {
+			//key file and temporary files
+			continue
+		}
+		etag, err := fileMD5(filepath.Join(l.uploadFolder(uploadID), f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, Part{PartNumber: partNumber, ETag: etag, Size: f.Size()})
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	return parts, nil
+}
+
+func (l *LocalStore) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	return os.RemoveAll(l.uploadFolder(uploadID))
+}
+
+func (l *LocalStore) ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	folders, err := ioutil.ReadDir(filepath.Join(l.config.RootFolder, LocalUploadFolder))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var uploads []MultipartUpload
+	for _, f := range folders {
+		if !f.IsDir() {
+			continue
+		}
+		keyFile := filepath.Join(l.uploadFolder(f.Name()), LocalUploadKeyFile)
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			//upload is being initiated or aborted
+			continue
+		}
+		if !strings.HasPrefix(string(key), prefix) {
+			continue
+		}
+		uploads = append(uploads, MultipartUpload{Key: string(key), UploadID: f.Name(), Initiated: f.ModTime()})
+	}
+	return uploads, nil
+}
+
+func (l *LocalStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(l.objectPath(key))
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *LocalStore) PublicURL(key string) string {
+	return joinURL(l.config.PublicBaseUrl, key)
+}
+
+// PresignGet returns the public url since files of local store are served by external web server which
+// has no knowledge of signature.
+func (l *LocalStore) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return l.PublicURL(key), nil
+}
+
+// writeAtomically writes content into a temporary file aside of target and renames it into place,
+// md5 of content is returned.
+func (l *LocalStore) writeAtomically(target string, write func(w io.Writer) error) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(target), fsutil.DefaultDirPerm); err != nil {
+		return "", err
+	}
+	tempFile, err := ioutil.TempFile(filepath.Dir(target), fmt.Sprintf(".%s.*.tmp", filepath.Base(target)))
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tempFile.Name())
+	hasher := md5.New()
+	if err = write(io.MultiWriter(tempFile, hasher)); err != nil {
+		tempFile.Close()
+		return "", err
+	}
+	if err = tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return "", err
+	}
+	if err = tempFile.Close(); err != nil {
+		return "", err
+	}
+	if err = os.Chmod(tempFile.Name(), 0644); err != nil {
+		return "", err
+	}
+	if err = os.Rename(tempFile.Name(), target); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func fileMD5(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := md5.New()
+	if _, err = io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
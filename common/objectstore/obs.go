@@ -0,0 +1,207 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/huaweicloud/huaweicloud-sdk-go-obs/obs"
+	"github.com/omnibuildplatform/omni-repository/common/config"
+)
+
+const NotFoundError = "Status=404 Not Found"
+const NoSuchUploadError = "NoSuchUpload"
+
+type OBSStore struct {
+	config    config.PublishTarget
+	obsClient *obs.ObsClient
+}
+
+func NewOBSStore(config config.PublishTarget) (ObjectStore, error) {
+	if len(config.AK) == 0 || len(config.SK) == 0 || len(config.Endpoint) == 0 {
+		return nil, errors.New("incorrect ak/sk/endpoint config for obs store")
+	}
+	obsClient, err := obs.New(config.AK, config.SK, fmt.Sprintf("https://%s", config.Endpoint))
+	if err != nil {
+		return nil, err
+	}
+	// check bucket whether exists
+	_, err = obsClient.GetBucketLocation(config.Bucket)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to get bucket information %s, %v", config.Bucket, err))
+	}
+	return &OBSStore{
+		config:    config,
+		obsClient: obsClient,
+	}, nil
+}
+
+func (o *OBSStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	input := &obs.GetObjectMetadataInput{}
+	input.Bucket = o.config.Bucket
+	input.Key = key
+	output, err := o.obsClient.GetObjectMetadata(input)
+	if err != nil {
+		if strings.Contains(err.Error(), NotFoundError) {
+			return ObjectInfo{}, os.ErrNotExist
+		}
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Key:  key,
+		Size: output.ContentLength,
+		ETag: strings.Trim(output.ETag, "\""),
+	}, nil
+}
+
+func (o *OBSStore) Put(ctx context.Context, key string, reader io.Reader, size int64) error {
+	input := &obs.PutObjectInput{}
+	input.Bucket = o.config.Bucket
+	input.Key = key
+	input.Body = reader
+	input.ContentLength = size
+	_, err := o.obsClient.PutObject(input)
+	return err
+}
+
+func (o *OBSStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	input := &obs.GetObjectInput{}
+	input.Bucket = o.config.Bucket
+	input.Key = key
+	output, err := o.obsClient.GetObject(input)
+	if err != nil {
+		if strings.Contains(err.Error(), NotFoundError) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+func (o *OBSStore) InitiateMultipart(ctx context.Context, key string) (string, error) {
+	input := &obs.InitiateMultipartUploadInput{}
+	input.Bucket = o.config.Bucket
+	input.Key = key
+	output, err := o.obsClient.InitiateMultipartUpload(input)
+	if err != nil {
+		return "", err
+	}
+	return output.UploadId, nil
+}
+
+func (o *OBSStore) UploadPart(ctx context.Context, key, uploadID string, partNumber int, localPath string, offset, size int64) (Part, error) {
+	input := &obs.UploadPartInput{}
+	input.Bucket = o.config.Bucket
+	input.Key = key
+	input.UploadId = uploadID
+	input.SourceFile = localPath
+	input.PartNumber = partNumber
+	input.Offset = offset
+	input.PartSize = size
+	output, err := o.obsClient.UploadPart(input)
+	if err != nil {
+		return Part{}, err
+	}
+	return Part{PartNumber: output.PartNumber, ETag: output.ETag, Size: size}, nil
+}
+
+func (o *OBSStore) CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error {
+	input := &obs.CompleteMultipartUploadInput{}
+	input.Bucket = o.config.Bucket
+	input.Key = key
+	input.UploadId = uploadID
+	for _, p := range parts {
+		input.Parts = append(input.Parts, obs.Part{PartNumber: p.PartNumber, ETag: p.ETag})
+	}
+	_, err := o.obsClient.CompleteMultipartUpload(input)
+	return err
+}
+
+func (o *OBSStore) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	input := &obs.ListPartsInput{}
+	input.Bucket = o.config.Bucket
+	input.Key = key
+	input.UploadId = uploadID
+	var parts []Part
+	for {
+		output, err := o.obsClient.ListParts(input)
+		if err != nil {
+			if strings.Contains(err.Error(), NoSuchUploadError) || strings.Contains(err.Error(), NotFoundError) {
+				return nil, os.ErrNotExist
+			}
+			return nil, err
+		}
+		for _, p := range output.Parts {
+			parts = append(parts, Part{PartNumber: p.PartNumber, ETag: p.ETag, Size: p.Size})
+		}
+		if !output.IsTruncated {
+			return parts, nil
+		}
+		input.PartNumberMarker = output.NextPartNumberMarker
+	}
+}
+
+func (o *OBSStore) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	input := &obs.AbortMultipartUploadInput{}
+	input.Bucket = o.config.Bucket
+	input.Key = key
+	input.UploadId = uploadID
+	_, err := o.obsClient.AbortMultipartUpload(input)
+	if err != nil && (strings.Contains(err.Error(), NoSuchUploadError) || strings.Contains(err.Error(), NotFoundError)) {
+		return nil
+	}
+	return err
+}
+
+func (o *OBSStore) ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	input := &obs.ListMultipartUploadsInput{}
+	input.Bucket = o.config.Bucket
+	input.Prefix = prefix
+	var uploads []MultipartUpload
+	for {
+		output, err := o.obsClient.ListMultipartUploads(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range output.Uploads {
+			uploads = append(uploads, MultipartUpload{Key: u.Key, UploadID: u.UploadId, Initiated: u.Initiated})
+		}
+		if !output.IsTruncated {
+			return uploads, nil
+		}
+		input.KeyMarker = output.NextKeyMarker
+		input.UploadIdMarker = output.NextUploadIdMarker
+	}
+}
+
+func (o *OBSStore) Delete(ctx context.Context, key string) error {
+	input := &obs.DeleteObjectInput{}
+	input.Bucket = o.config.Bucket
+	input.Key = key
+	_, err := o.obsClient.DeleteObject(input)
+	return err
+}
+
+func (o *OBSStore) PublicURL(key string) string {
+	if len(o.config.PublicBaseUrl) != 0 {
+		return joinURL(o.config.PublicBaseUrl, key)
+	}
+	return fmt.Sprintf("https://%s.%s/%s", o.config.Bucket, o.config.Endpoint, strings.TrimLeft(key, "/"))
+}
+
+func (o *OBSStore) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	input := &obs.CreateSignedUrlInput{}
+	input.Method = obs.HttpMethodGet
+	input.Bucket = o.config.Bucket
+	input.Key = key
+	input.Expires = int(expiry.Seconds())
+	output, err := o.obsClient.CreateSignedUrl(input)
+	if err != nil {
+		return "", err
+	}
+	return output.SignedUrl, nil
+}
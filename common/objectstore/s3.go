@@ -0,0 +1,189 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/omnibuildplatform/omni-repository/common/config"
+)
+
+// S3Store talks to S3 compatible services, e.g. AWS S3, MinIO and Ceph RGW.
+type S3Store struct {
+	config config.PublishTarget
+	client *minio.Core
+}
+
+func NewS3Store(config config.PublishTarget) (ObjectStore, error) {
+	if len(config.AK) == 0 || len(config.SK) == 0 || len(config.Endpoint) == 0 {
+		return nil, errors.New("incorrect ak/sk/endpoint config for s3 store")
+	}
+	lookup := minio.BucketLookupAuto
+	if config.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.NewCore(config.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(config.AK, config.SK, ""),
+		Secure:       !config.Insecure,
+		Region:       config.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// check bucket whether exists
+	exists, err := client.BucketExists(context.Background(), config.Bucket)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to get bucket information %s, %v", config.Bucket, err))
+	}
+	if !exists {
+		return nil, errors.New(fmt.Sprintf("bucket %s not existed", config.Bucket))
+	}
+	return &S3Store{
+		config: config,
+		client: client,
+	}, nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.config.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).StatusCode == 404 {
+			return ObjectInfo{}, os.ErrNotExist
+		}
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Key:  key,
+		Size: info.Size,
+		ETag: strings.Trim(info.ETag, "\""),
+	}, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, reader io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.config.Bucket, key, reader, size, "", "", minio.PutObjectOptions{})
+	return err
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	reader, _, _, err := s.client.GetObject(ctx, s.config.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).StatusCode == 404 {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return reader, nil
+}
+
+func (s *S3Store) InitiateMultipart(ctx context.Context, key string) (string, error) {
+	return s.client.NewMultipartUpload(ctx, s.config.Bucket, key, minio.PutObjectOptions{})
+}
+
+func (s *S3Store) UploadPart(ctx context.Context, key, uploadID string, partNumber int, localPath string, offset, size int64) (Part, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return Part{}, err
+	}
+	defer f.Close()
+	part, err := s.client.PutObjectPart(ctx, s.config.Bucket, key, uploadID, partNumber,
+		io.NewSectionReader(f, offset, size), size, "", "", nil)
+	if err != nil {
+		return Part{}, err
+	}
+	return Part{PartNumber: part.PartNumber, ETag: part.ETag, Size: size}, nil
+}
+
+func (s *S3Store) CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error {
+	completeParts := make([]minio.CompletePart, 0, len(parts))
+	for _, p := range parts {
+		completeParts = append(completeParts, minio.CompletePart{PartNumber: p.PartNumber, ETag: p.ETag})
+	}
+	//parts must be in ascending order
+	sort.Slice(completeParts, func(i, j int) bool {
+		return completeParts[i].PartNumber < completeParts[j].PartNumber
+	})
+	_, err := s.client.CompleteMultipartUpload(ctx, s.config.Bucket, key, uploadID, completeParts, minio.PutObjectOptions{})
+	return err
+}
+
+func (s *S3Store) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	var parts []Part
+	marker := 0
+	for {
+		result, err := s.client.ListObjectParts(ctx, s.config.Bucket, key, uploadID, marker, 1000)
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchUpload" {
+				return nil, os.ErrNotExist
+			}
+			return nil, err
+		}
+		for _, p := range result.ObjectParts {
+			parts = append(parts, Part{PartNumber: p.PartNumber, ETag: p.ETag, Size: p.Size})
+		}
+		if !result.IsTruncated {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+func (s *S3Store) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	err := s.client.AbortMultipartUpload(ctx, s.config.Bucket, key, uploadID)
+	if err != nil && minio.ToErrorResponse(err).Code == "NoSuchUpload" {
+		return nil
+	}
+	return err
+}
+
+func (s *S3Store) ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	var uploads []MultipartUpload
+	keyMarker, uploadIDMarker := "", ""
+	for {
+		result, err := s.client.ListMultipartUploads(ctx, s.config.Bucket, prefix, keyMarker, uploadIDMarker, "", 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range result.Uploads {
+			uploads = append(uploads, MultipartUpload{Key: u.Key, UploadID: u.UploadID, Initiated: u.Initiated})
+		}
+		if !result.IsTruncated {
+			return uploads, nil
+		}
+		keyMarker = result.NextKeyMarker
+		uploadIDMarker = result.NextUploadIDMarker
+	}
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.config.Bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3Store) PublicURL(key string) string {
+	if len(s.config.PublicBaseUrl) != 0 {
+		return joinURL(s.config.PublicBaseUrl, key)
+	}
+	scheme := "https"
+	if s.config.Insecure {
+		scheme = "http"
+	}
+	if s.config.PathStyle {
+		return fmt.Sprintf("%s://%s/%s/%s", scheme, s.config.Endpoint, s.config.Bucket, strings.TrimLeft(key, "/"))
+	}
+	return fmt.Sprintf("%s://%s.%s/%s", scheme, s.config.Bucket, s.config.Endpoint, strings.TrimLeft(key, "/"))
+}
+
+func (s *S3Store) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	u, err := s.client.PresignedGetObject(ctx, s.config.Bucket, key, expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
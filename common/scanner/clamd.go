@@ -0,0 +1,145 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/omnibuildplatform/omni-repository/common/config"
+)
+
+const (
+	DefaultClamdChunkSize = 1024 * 1024
+	DefaultClamdTimeout   = 600
+)
+
+// ClamdScanner streams content to clamd with the INSTREAM command, see clamd(8).
+type ClamdScanner struct {
+	network   string
+	address   string
+	chunkSize int
+	timeout   time.Duration
+}
+
+func NewClamdScanner(config config.ImageScanner) (Scanner, error) {
+	if len(config.Address) == 0 {
+		return nil, errors.New("incorrect address config for clamd scanner")
+	}
+	network := config.Network
+	if len(network) == 0 {
+		network = "tcp"
+	}
+	if network != "tcp" && network != "unix" {
+		return nil, errors.New(fmt.Sprintf("unsupported network %s for clamd scanner", network))
+	}
+	chunkSize := config.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultClamdChunkSize
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultClamdTimeout
+	}
+	return &ClamdScanner{
+		network:   network,
+		address:   config.Address,
+		chunkSize: chunkSize,
+		timeout:   time.Duration(timeout) * time.Second,
+	}, nil
+}
+
+func (c *ClamdScanner) Scan(ctx context.Context, reader io.Reader) (Result, error) {
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return Result{}, &UnavailableError{Err: err}
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	// abort blocking io when context cancelled
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	if _, err = conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, &UnavailableError{Err: err}
+	}
+	chunk := make([]byte, c.chunkSize)
+	sizeHeader := make([]byte, 4)
+	for {
+		n, readErr := reader.Read(chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(sizeHeader, uint32(n))
+			if _, err = conn.Write(sizeHeader); err != nil {
+				return Result{}, c.streamError(conn, err)
+			}
+			if _, err = conn.Write(chunk[:n]); err != nil {
+				return Result{}, c.streamError(conn, err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, readErr
+		}
+	}
+	//zero length chunk terminates the stream
+	binary.BigEndian.PutUint32(sizeHeader, 0)
+	if _, err = conn.Write(sizeHeader); err != nil {
+		return Result{}, c.streamError(conn, err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\x00')
+	if err != nil && err != io.EOF {
+		return Result{}, &UnavailableError{Err: err}
+	}
+	if len(reply) == 0 {
+		return Result{}, &UnavailableError{Err: errors.New("connection closed by clamd without reply")}
+	}
+	return parseClamdReply(reply)
+}
+
+// streamError prefers the reply of clamd, since clamd closes connection when stream exceeds its size limit,
+// connection lost without reply tells clamd is unavailable.
+func (c *ClamdScanner) streamError(conn net.Conn, err error) error {
+	reply, readErr := bufio.NewReader(conn).ReadString('\x00')
+	if readErr != nil && len(reply) == 0 {
+		return &UnavailableError{Err: err}
+	}
+	if _, replyErr := parseClamdReply(reply); replyErr != nil {
+		return replyErr
+	}
+	return err
+}
+
+// parseClamdReply parses reply in the format of "stream: OK", "stream: <signature> FOUND" or "<message> ERROR".
+func parseClamdReply(reply string) (Result, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	reply = strings.TrimPrefix(reply, "stream:")
+	reply = strings.TrimSpace(reply)
+	switch {
+	case reply == "OK":
+		return Result{Infected: false}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	case strings.HasSuffix(reply, " ERROR"):
+		return Result{}, errors.New(fmt.Sprintf("clamd failed to scan content, %s", strings.TrimSuffix(reply, " ERROR")))
+	}
+	return Result{}, errors.New(fmt.Sprintf("unrecognized clamd reply %s", reply))
+}
@@ -0,0 +1,133 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/omnibuildplatform/omni-repository/common/config"
+)
+
+// fakeClamd accepts a single INSTREAM session, reply is called with the streamed content and its result is
+// sent back, connection is closed without reply when reply returns empty.
+func fakeClamd(t *testing.T, reply func(content []byte) string) (string, <-chan []byte) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen, %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		command := make([]byte, len("zINSTREAM\x00"))
+		if _, err = io.ReadFull(conn, command); err != nil || string(command) != "zINSTREAM\x00" {
+			return
+		}
+		var content bytes.Buffer
+		sizeHeader := make([]byte, 4)
+		for {
+			if _, err = io.ReadFull(conn, sizeHeader); err != nil {
+				return
+			}
+			size := binary.BigEndian.Uint32(sizeHeader)
+			if size == 0 {
+				break
+			}
+			if _, err = io.CopyN(&content, conn, int64(size)); err != nil {
+				return
+			}
+		}
+		received <- content.Bytes()
+		if answer := reply(content.Bytes()); len(answer) != 0 {
+			_, _ = conn.Write([]byte(answer))
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func newTestScanner(t *testing.T, address string) Scanner {
+	scanner, err := NewClamdScanner(config.ImageScanner{Type: ClamdScannerType, Address: address, ChunkSize: 4})
+	if err != nil {
+		t.Fatalf("failed to create clamd scanner, %v", err)
+	}
+	return scanner
+}
+
+func TestClamdScan(t *testing.T) {
+	content := "content streamed in chunks"
+	cases := []struct {
+		name        string
+		reply       string
+		result      Result
+		errContains string
+		unavailable bool
+	}{
+		{name: "clean", reply: "stream: OK\x00", result: Result{}},
+		{name: "infected", reply: "stream: Eicar-Test-Signature FOUND\x00", result: Result{Infected: true, Signature: "Eicar-Test-Signature"}},
+		{name: "clamd error", reply: "INSTREAM size limit exceeded. ERROR\x00", errContains: "size limit exceeded"},
+		{name: "closed without reply", reply: "", unavailable: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			address, received := fakeClamd(t, func([]byte) string { return c.reply })
+			result, err := newTestScanner(t, address).Scan(context.Background(), strings.NewReader(content))
+			if streamed := <-received; string(streamed) != content {
+				t.Errorf("clamd received %q, expected %q", streamed, content)
+			}
+			var unavailable *UnavailableError
+			if errors.As(err, &unavailable) != c.unavailable {
+				t.Fatalf("unexpected unavailable state of error %v", err)
+			}
+			if len(c.errContains) != 0 && (err == nil || !strings.Contains(err.Error(), c.errContains)) {
+				t.Fatalf("expected error containing %q, got %v", c.errContains, err)
+			}
+			if len(c.errContains) == 0 && !c.unavailable && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if result != c.result {
+				t.Errorf("result %+v, expected %+v", result, c.result)
+			}
+		})
+	}
+}
+
+func TestClamdScanDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen, %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	_, err = newTestScanner(t, address).Scan(context.Background(), strings.NewReader("content"))
+	var unavailable *UnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Fatalf("expected unavailable error when clamd is down, got %v", err)
+	}
+}
+
+func TestClamdScanTimeout(t *testing.T) {
+	//clamd takes the stream but never answers
+	hold := make(chan struct{})
+	defer close(hold)
+	address, _ := fakeClamd(t, func([]byte) string {
+		<-hold
+		return ""
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := newTestScanner(t, address).Scan(ctx, strings.NewReader("content"))
+	var unavailable *UnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Fatalf("expected unavailable error when clamd times out, got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/omnibuildplatform/omni-repository/common/config"
+)
+
+const ClamdScannerType = "clamd"
+
+type (
+	Result struct {
+		Infected  bool
+		Signature string
+	}
+
+	Scanner interface {
+		Scan(ctx context.Context, reader io.Reader) (Result, error)
+	}
+)
+
+// UnavailableError tells scanner can't be reached or didn't answer in time, content can be scanned again later.
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("scanner unavailable, %v", e.Err)
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// Enabled reports whether content scanning stage is configured.
+func Enabled(config config.ImageScanner) bool {
+	return len(config.Type) != 0
+}
+
+func NewScanner(config config.ImageScanner) (Scanner, error) {
+	switch config.Type {
+	case ClamdScannerType:
+		return NewClamdScanner(config)
+	}
+	return nil, errors.New(fmt.Sprintf("unsupported scanner type %s", config.Type))
+}
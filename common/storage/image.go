@@ -27,10 +27,22 @@ func (i *ImageStorage) AddImage(m *models.Image) (err error) {
 	return result.Error
 }
 
-func (i *ImageStorage) SoftDeleteImage(m *models.Image) (err error) {
+// SoftDeleteImage marks image deleted and queues job of jobType to clean it up in one transaction.
+func (i *ImageStorage) SoftDeleteImage(m *models.Image, jobType string) (err error) {
 	m.UpdateTime = time.Now()
 	m.Deleted = true
-	result := i.db.WithContext(i.context).Model(m).Select("deleted", "update_time").Updates(m)
+	return i.db.WithContext(i.context).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(m).Select("deleted", "update_time").Updates(m).Error; err != nil {
+			return err
+		}
+		job := NewJob(jobType, *m, 0, time.Now())
+		return enqueueJob(tx, &job)
+	})
+}
+
+func (i *ImageStorage) UpdateImagePublish(m *models.Image) (err error) {
+	m.UpdateTime = time.Now()
+	result := i.db.WithContext(i.context).Model(m).Select("publish", "update_time").Updates(m)
 	return result.Error
 }
 
@@ -45,9 +57,30 @@ func (i *ImageStorage) UpdateImageStatus(m *models.Image) (err error) {
 	return result.Error
 }
 
-func (i *ImageStorage) UpdateImageExternalPath(m *models.Image) (err error) {
+func (i *ImageStorage) UpdateImageFileInfo(m *models.Image) (err error) {
+	m.UpdateTime = time.Now()
+	result := i.db.WithContext(i.context).Model(m).Select("size", "media_type", "update_time").Updates(m)
+	return result.Error
+}
+
+func (i *ImageStorage) UpdateImageStageTime(m *models.Image) (err error) {
+	m.UpdateTime = time.Now()
+	result := i.db.WithContext(i.context).Model(m).Select("download_start_time", "download_finish_time",
+		"verify_start_time", "verify_finish_time", "push_start_time", "push_finish_time", "update_time").Updates(m)
+	return result.Error
+}
+
+func (i *ImageStorage) UpdateImagePipelineStage(m *models.Image) error {
 	m.UpdateTime = time.Now()
-	result := i.db.WithContext(i.context).Model(m).Select("image_path", "checksum_path", "update_time").Updates(m)
+	result := i.db.WithContext(i.context).Model(m).Select("stage", "update_time").Updates(m)
+	return result.Error
+}
+
+// UpdateImageRetry saves the failure of image and when it will be retried.
+func (i *ImageStorage) UpdateImageRetry(m *models.Image) error {
+	m.UpdateTime = time.Now()
+	result := i.db.WithContext(i.context).Model(m).Select("failed_stage", "failure_class", "attempts", "retry_time",
+		"update_time").Updates(m)
 	return result.Error
 }
 
@@ -63,52 +96,81 @@ func (i *ImageStorage) UpdateImageStatusAndDetail(m *models.Image) error {
 	return result.Error
 }
 
+// TransitImageStatus saves status and detail of image only when image is still in one of expected statuses,
+// false is returned when image has been moved by others meanwhile, e.g. cancelled.
+func (i *ImageStorage) TransitImageStatus(m *models.Image, expected ...models.ImageStatus) (bool, error) {
+	m.UpdateTime = time.Now()
+	result := i.db.WithContext(i.context).Model(&models.Image{}).Where("id = ? AND status IN ?", m.ID, expected).
+		Updates(map[string]interface{}{
+			"status":        m.Status,
+			"status_detail": m.StatusDetail,
+			"update_time":   m.UpdateTime,
+		})
+	return result.RowsAffected != 0, result.Error
+}
+
 func (i *ImageStorage) GetImageByID(id int) (models.Image, error) {
 	var image models.Image
 	result := i.db.WithContext(i.context).Where("deleted = ?", false).First(&image, id)
 	return image, result.Error
 }
 
+// GetImageByIDIncludingDeleted returns image even when it's soft deleted, used by works which clean up deleted images.
+func (i *ImageStorage) GetImageByIDIncludingDeleted(id int) (models.Image, error) {
+	var image models.Image
+	result := i.db.WithContext(i.context).First(&image, id)
+	return image, result.Error
+}
+
 func (i *ImageStorage) GetImagesByStatus(status models.ImageStatus, limit int) ([]models.Image, error) {
 	var images []models.Image
 	result := i.db.WithContext(i.context).Where("status = ? AND deleted = ? ", status, false).Order("create_time desc").Limit(limit).Find(&images)
 	return images, result.Error
 }
 
-func (i *ImageStorage) GetImageForDownload(limit int) ([]models.Image, error) {
+func (i *ImageStorage) GetImageForScrub(before time.Time, limit int) ([]models.Image, error) {
 	var images []models.Image
-	result := i.db.WithContext(i.context).Where("status = ? AND source_url != '' AND deleted = ?", models.ImageCreated, false).Order("create_time desc").Limit(limit).Find(&images)
+	result := i.db.WithContext(i.context).Where("status IN ? AND deleted = ? AND evicted = ? AND (last_scrub_time IS NULL OR last_scrub_time < ?)",
+		[]models.ImageStatus{models.ImageVerified, models.ImageScanned, models.ImagePushed, models.ImageUnpublished}, false, false, before).Order("last_scrub_time asc").Limit(limit).Find(&images)
 	return images, result.Error
 }
 
-func (i *ImageStorage) GetDownloadingImages() ([]models.Image, error) {
-	var images []models.Image
-	result := i.db.WithContext(i.context).Where("status = ? AND deleted = ?", models.ImageDownloading, false).Order("create_time desc").Find(&images)
-	return images, result.Error
+func (i *ImageStorage) UpdateImageScrubResult(m *models.Image) error {
+	m.UpdateTime = time.Now()
+	result := i.db.WithContext(i.context).Model(m).Select("last_scrub_time", "last_scrub_result", "update_time").Updates(m)
+	return result.Error
 }
 
-func (i *ImageStorage) GetPushingImages() ([]models.Image, error) {
-	var images []models.Image
-	result := i.db.WithContext(i.context).Where("status = ? AND deleted = ?", models.ImagePushing, false).Order("create_time desc").Find(&images)
-	return images, result.Error
+func (i *ImageStorage) UpdateImageCache(m *models.Image) error {
+	m.UpdateTime = time.Now()
+	result := i.db.WithContext(i.context).Model(m).Select("evicted", "last_access_time", "update_time").Updates(m)
+	return result.Error
 }
 
-func (i *ImageStorage) GetImageForVerify(limit int) ([]models.Image, error) {
-	var images []models.Image
-	result := i.db.WithContext(i.context).Where("status = ? AND deleted = ?", models.ImageDownloaded, false).Order("create_time desc").Limit(limit).Find(&images)
-	return images, result.Error
+// TouchImage records access of local copy without changing update time.
+func (i *ImageStorage) TouchImage(m *models.Image) error {
+	now := time.Now()
+	m.LastAccessTime = &now
+	result := i.db.WithContext(i.context).Model(m).UpdateColumn("last_access_time", now)
+	return result.Error
 }
 
-func (i *ImageStorage) GetImageForPush(limit int) ([]models.Image, error) {
+// GetImageForEviction returns published images with local copy and at least one pushed replica to re-fetch from,
+// least recently used comes first.
+func (i *ImageStorage) GetImageForEviction(limit int) ([]models.Image, error) {
 	var images []models.Image
-	result := i.db.WithContext(i.context).Where("status = ? AND publish = ? AND deleted = ?", models.ImageVerified, true, false).Order("create_time desc").Limit(limit).Find(&images)
+	result := i.db.WithContext(i.context).Where("status = ? AND evicted = ? AND deleted = ?", models.ImagePushed, false, false).
+		Where("EXISTS (SELECT 1 FROM image_replicas WHERE image_replicas.image_id = images.id AND image_replicas.status = ?)", models.ReplicaPushed).
+		Order("COALESCE(last_access_time, update_time) asc").Limit(limit).Find(&images)
 	return images, result.Error
 }
 
-func (i *ImageStorage) GetImageForClean(limit int) ([]models.Image, error) {
-	var images []models.Image
-	result := i.db.WithContext(i.context).Where("deleted = ?", true).Order("create_time desc").Limit(limit).Find(&images)
-	return images, result.Error
+// GetCachedImageSize returns total size of images with local copy.
+func (i *ImageStorage) GetCachedImageSize() (int64, error) {
+	var size int64
+	result := i.db.WithContext(i.context).Model(&models.Image{}).Where("evicted = ? AND deleted = ?", false, false).
+		Select("COALESCE(SUM(size), 0)").Scan(&size)
+	return size, result.Error
 }
 
 func (i *ImageStorage) GetImagesByUserID(userid, offset, limit int) ([]models.Image, error) {
@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/omnibuildplatform/omni-repository/common/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// mysqlDuplicateEntry is the mysql error number of unique key violation.
+const mysqlDuplicateEntry = 1062
+
+var activeJobStates = []models.JobState{models.JobPending, models.JobRunning}
+
+// ClaimCandidateFactor decides how many due jobs are considered for each job claimed.
+const ClaimCandidateFactor = 10
+
+// JobLeaseDuration is how long a claimed job belongs to its owner without renewal, jobs of instances which
+// stopped renewing are requeued by others.
+const JobLeaseDuration = 2 * time.Minute
+
+func NewJob(jobType string, image models.Image, replicaID int, runAfter time.Time) models.Job {
+	return models.Job{
+		Type:      jobType,
+		ImageID:   image.ID,
+		ReplicaID: replicaID,
+		UserID:    image.UserId,
+		Component: image.ExternalComponent,
+		Priority:  image.Priority,
+		State:     models.JobPending,
+		RunAfter:  runAfter,
+	}
+}
+
+// enqueueJob inserts job unless an identical one is active, active key is unique so that instances enqueueing
+// the same job at the same time end up with a single one.
+func enqueueJob(tx *gorm.DB, m *models.Job) error {
+	var count int64
+	err := tx.Model(&models.Job{}).Where("type = ? AND image_id = ? AND replica_id = ? AND state IN ?",
+		m.Type, m.ImageID, m.ReplicaID, activeJobStates).Count(&count).Error
+	if err != nil || count != 0 {
+		return err
+	}
+	activeKey := fmt.Sprintf("%s/%d/%d", m.Type, m.ImageID, m.ReplicaID)
+	m.ActiveKey = &activeKey
+	m.CreateTime = time.Now()
+	m.UpdateTime = time.Now()
+	if m.RunAfter.IsZero() {
+		m.RunAfter = m.CreateTime
+	}
+	err = tx.Create(m).Error
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		//enqueued by another instance meanwhile
+		return nil
+	}
+	return err
+}
+
+// EnqueueJobs inserts jobs, jobs identical to an active one are skipped.
+func (i *ImageStorage) EnqueueJobs(jobs []models.Job) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	return i.db.WithContext(i.context).Transaction(func(tx *gorm.DB) error {
+		for index := range jobs {
+			if err := enqueueJob(tx, &jobs[index]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ClaimJobs marks jobs chosen by pick from due jobs running and returns them, rows locked by other instances
+// are skipped. Candidates are the most urgent and the oldest due jobs, running jobs are passed to pick for
+// fair scheduling. Only jobs of work types in types are claimed.
+func (i *ImageStorage) ClaimJobs(owner string, limit int, types []string, pick func(candidates, running []models.Job, limit int) []models.Job) ([]models.Job, error) {
+	var jobs []models.Job
+	err := i.db.WithContext(i.context).Transaction(func(tx *gorm.DB) error {
+		var urgent, oldest, running []models.Job
+		due := func() *gorm.DB {
+			return tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("state = ? AND run_after <= ? AND type IN ?", models.JobPending, time.Now(), types)
+		}
+		err := due().Order("priority desc, run_after asc, id asc").Limit(limit * ClaimCandidateFactor).Find(&urgent).Error
+		if err != nil || len(urgent) == 0 {
+			return err
+		}
+		err = due().Order("run_after asc, id asc").Limit(limit * ClaimCandidateFactor).Find(&oldest).Error
+		if err != nil {
+			return err
+		}
+		candidates := urgent
+		seen := make(map[int]bool, len(urgent))
+		for _, job := range urgent {
+			seen[job.ID] = true
+		}
+		for _, job := range oldest {
+			if !seen[job.ID] {
+				candidates = append(candidates, job)
+			}
+		}
+		err = tx.Model(&models.Job{}).Select("user_id", "component").Where("state = ?", models.JobRunning).Find(&running).Error
+		if err != nil {
+			return err
+		}
+		jobs = pick(candidates, running, limit)
+		leaseExpireTime := time.Now().Add(JobLeaseDuration)
+		for index := range jobs {
+			jobs[index].State = models.JobRunning
+			jobs[index].Attempts += 1
+			jobs[index].Owner = owner
+			jobs[index].LeaseExpireTime = &leaseExpireTime
+			jobs[index].UpdateTime = time.Now()
+			err = tx.Model(&jobs[index]).Select("state", "attempts", "effective_priority", "schedule_note", "owner",
+				"lease_expire_time", "update_time").Updates(&jobs[index]).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// FinishJob records final state of job, the same job can be enqueued again afterwards.
+func (i *ImageStorage) FinishJob(m *models.Job) error {
+	m.ActiveKey = nil
+	m.UpdateTime = time.Now()
+	result := i.db.WithContext(i.context).Model(m).Select("state", "last_error", "active_key", "update_time").Updates(m)
+	return result.Error
+}
+
+// RequeueJob returns job which didn't run to the end to the queue, the claim is not counted as an attempt.
+func (i *ImageStorage) RequeueJob(m *models.Job, runAfter time.Time) error {
+	m.State = models.JobPending
+	m.RunAfter = runAfter
+	m.Owner = ""
+	m.LeaseExpireTime = nil
+	m.UpdateTime = time.Now()
+	if m.Attempts > 0 {
+		m.Attempts -= 1
+	}
+	result := i.db.WithContext(i.context).Model(m).Select("state", "run_after", "attempts", "last_error", "owner",
+		"lease_expire_time", "update_time").Updates(m)
+	return result.Error
+}
+
+// ResetRunningJobs returns jobs of owner interrupted by its restart to the queue, as well as jobs whose owner
+// stopped renewing their lease. Jobs of live instances are left running.
+func (i *ImageStorage) ResetRunningJobs(owner string) (int64, error) {
+	result := i.db.WithContext(i.context).Model(&models.Job{}).Where("state = ?", models.JobRunning).
+		Where("owner = ? OR lease_expire_time IS NULL OR lease_expire_time < ?", owner, time.Now()).
+		Updates(map[string]interface{}{
+			"state":             models.JobPending,
+			"run_after":         time.Now(),
+			"owner":             "",
+			"lease_expire_time": nil,
+			"update_time":       time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// ResetExpiredJobs returns running jobs whose owner stopped renewing their lease to the queue.
+func (i *ImageStorage) ResetExpiredJobs() (int64, error) {
+	result := i.db.WithContext(i.context).Model(&models.Job{}).
+		Where("state = ? AND lease_expire_time < ?", models.JobRunning, time.Now()).
+		Updates(map[string]interface{}{
+			"state":             models.JobPending,
+			"run_after":         time.Now(),
+			"owner":             "",
+			"lease_expire_time": nil,
+			"update_time":       time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// RenewJobLeases extends lease of all running jobs claimed by owner.
+func (i *ImageStorage) RenewJobLeases(owner string) error {
+	result := i.db.WithContext(i.context).Model(&models.Job{}).
+		Where("state = ? AND owner = ?", models.JobRunning, owner).
+		Update("lease_expire_time", time.Now().Add(JobLeaseDuration))
+	return result.Error
+}
+
+func (i *ImageStorage) GetJobs(state models.JobState, jobType string, imageID, limit int) ([]models.Job, error) {
+	var jobs []models.Job
+	query := i.db.WithContext(i.context).Model(&models.Job{})
+	if len(state) != 0 {
+		query = query.Where("state = ?", state)
+	}
+	if len(jobType) != 0 {
+		query = query.Where("type = ?", jobType)
+	}
+	if imageID != 0 {
+		query = query.Where("image_id = ?", imageID)
+	}
+	result := query.Order("id desc").Limit(limit).Find(&jobs)
+	return jobs, result.Error
+}
+
+// GetRecentFailedJobs returns failed jobs, the latest failed comes first.
+func (i *ImageStorage) GetRecentFailedJobs(limit int) ([]models.Job, error) {
+	var jobs []models.Job
+	result := i.db.WithContext(i.context).Where("state = ?", models.JobFailed).
+		Order("update_time desc").Limit(limit).Find(&jobs)
+	return jobs, result.Error
+}
+
+// SetWorkIntakePaused pauses or resumes claiming jobs of workType on all instances.
+func (i *ImageStorage) SetWorkIntakePaused(workType string, paused bool) error {
+	intake := models.WorkIntake{Type: workType, Paused: paused, UpdateTime: time.Now()}
+	return i.db.WithContext(i.context).Clauses(clause.OnConflict{
+		UpdateAll: true,
+	}).Create(&intake).Error
+}
+
+// GetPausedWorkTypes returns the work types whose intake is paused.
+func (i *ImageStorage) GetPausedWorkTypes() (map[string]bool, error) {
+	var intakes []models.WorkIntake
+	result := i.db.WithContext(i.context).Where("paused = ?", true).Find(&intakes)
+	paused := make(map[string]bool, len(intakes))
+	for _, intake := range intakes {
+		paused[intake.Type] = true
+	}
+	return paused, result.Error
+}
+
+// RenameWorkType moves jobs, failed stages of images and intake state recorded under work type from to work
+// type to, returns the number of jobs renamed.
+func (i *ImageStorage) RenameWorkType(from, to string) (int64, error) {
+	var renamed int64
+	err := i.db.WithContext(i.context).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Job{}).Where("type = ?", from).Updates(map[string]interface{}{
+			"type":       to,
+			"active_key": gorm.Expr("REPLACE(active_key, ?, ?)", from+"/", to+"/"),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		renamed = result.RowsAffected
+		if err := tx.Model(&models.Image{}).Where("failed_stage = ?", from).
+			Update("failed_stage", to).Error; err != nil {
+			return err
+		}
+		var intakes []models.WorkIntake
+		if err := tx.Where("type = ?", from).Find(&intakes).Error; err != nil || len(intakes) == 0 {
+			return err
+		}
+		if err := tx.Where("type = ?", from).Delete(&models.WorkIntake{}).Error; err != nil {
+			return err
+		}
+		intake := models.WorkIntake{Type: to, Paused: intakes[0].Paused, UpdateTime: time.Now()}
+		return tx.Clauses(clause.OnConflict{
+			UpdateAll: true,
+		}).Create(&intake).Error
+	})
+	return renamed, err
+}
+
+// CountJobsByType returns the number of jobs in state of each work type.
+func (i *ImageStorage) CountJobsByType(state models.JobState) (map[string]int64, error) {
+	var rows []struct {
+		Type  string
+		Count int64
+	}
+	result := i.db.WithContext(i.context).Model(&models.Job{}).Select("type, count(*) as count").
+		Where("state = ?", state).Group("type").Scan(&rows)
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Type] = row.Count
+	}
+	return counts, result.Error
+}
+
+// GetImagesWithoutActiveJob returns images which may need further works while no job is queued for them,
+// e.g. images created before jobs were introduced.
+func (i *ImageStorage) GetImagesWithoutActiveJob(afterID, limit int) ([]models.Image, error) {
+	var images []models.Image
+	result := i.db.WithContext(i.context).Where("id > ?", afterID).
+		Where("NOT EXISTS (SELECT 1 FROM jobs WHERE jobs.image_id = images.id AND jobs.state IN ?)", activeJobStates).
+		Where("deleted = ? OR status IN ? OR EXISTS (SELECT 1 FROM image_replicas WHERE image_replicas.image_id = images.id AND image_replicas.status IN ?)",
+			true, []models.ImageStatus{models.ImageCreated, models.ImageDownloading, models.ImageDownloaded, models.ImageVerifying,
+				models.ImageVerified, models.ImageScanning, models.ImageScanned, models.ImagePushing},
+			[]models.ReplicaStatus{models.ReplicaPending, models.ReplicaPushing, models.ReplicaFailed, models.ReplicaRemoteCleanupPending}).
+		Order("id asc").Limit(limit).Find(&images)
+	return images, result.Error
+}
@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/omnibuildplatform/omni-repository/common/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AddImageWithReplicas creates image as well as its pending replicas on publish targets, the first jobs
+// returned by plan are queued in the same transaction.
+func (i *ImageStorage) AddImageWithReplicas(m *models.Image, targets []string, plan func(image models.Image, replicas []models.ImageReplica) []models.Job) error {
+	m.CreateTime = time.Now()
+	m.UpdateTime = time.Now()
+	if len(m.Status) == 0 {
+		m.Status = models.ImageCreated
+	}
+	return i.db.WithContext(i.context).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(m).Create(m).Error; err != nil {
+			return err
+		}
+		replicas := make([]models.ImageReplica, 0, len(targets))
+		for _, target := range targets {
+			replica := models.ImageReplica{
+				ImageID:    m.ID,
+				Target:     target,
+				Status:     models.ReplicaPending,
+				CreateTime: time.Now(),
+				UpdateTime: time.Now(),
+			}
+			if err := tx.Create(&replica).Error; err != nil {
+				return err
+			}
+			replicas = append(replicas, replica)
+		}
+		jobs := plan(*m, replicas)
+		for index := range jobs {
+			if err := enqueueJob(tx, &jobs[index]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (i *ImageStorage) AddReplica(m *models.ImageReplica) error {
+	m.CreateTime = time.Now()
+	m.UpdateTime = time.Now()
+	if len(m.Status) == 0 {
+		m.Status = models.ReplicaPending
+	}
+	result := i.db.WithContext(i.context).Create(m)
+	return result.Error
+}
+
+func (i *ImageStorage) UpdateReplica(m *models.ImageReplica) error {
+	m.UpdateTime = time.Now()
+	result := i.db.WithContext(i.context).Model(m).Select("status", "status_detail", "image_url", "checksum_url",
+		"attempts", "retry_time", "update_time").Updates(m)
+	return result.Error
+}
+
+// TransitReplica saves replica only when it's still in one of expected statuses, false is returned when
+// replica has been moved by others meanwhile, e.g. push cancelled.
+func (i *ImageStorage) TransitReplica(m *models.ImageReplica, expected ...models.ReplicaStatus) (bool, error) {
+	m.UpdateTime = time.Now()
+	result := i.db.WithContext(i.context).Model(m).Where("status IN ?", expected).Select("status", "status_detail",
+		"image_url", "checksum_url", "attempts", "retry_time", "update_time").Updates(m)
+	return result.RowsAffected != 0, result.Error
+}
+
+// RequestReplicaVerification requests verification of all pushed replicas of image, it returns the number
+// of replicas requested.
+func (i *ImageStorage) RequestReplicaVerification(imageID int) (int64, error) {
+	now := time.Now()
+	result := i.db.WithContext(i.context).Model(&models.ImageReplica{}).
+		Where("image_id = ? AND status = ?", imageID, models.ReplicaPushed).
+		Updates(map[string]interface{}{"verify_request_time": now, "update_time": now})
+	return result.RowsAffected, result.Error
+}
+
+// ClearReplicaVerification drops the pending verification request of replica.
+func (i *ImageStorage) ClearReplicaVerification(m *models.ImageReplica) error {
+	m.VerifyRequestTime = nil
+	m.UpdateTime = time.Now()
+	result := i.db.WithContext(i.context).Model(m).Select("verify_request_time", "update_time").Updates(m)
+	return result.Error
+}
+
+// UpdateReplicaUpload persists the in-progress multipart upload of replica so that push can be resumed.
+func (i *ImageStorage) UpdateReplicaUpload(m *models.ImageReplica) error {
+	m.UpdateTime = time.Now()
+	result := i.db.WithContext(i.context).Model(m).Select("upload_key", "upload_id", "upload_parts",
+		"total_bytes", "uploaded_bytes", "throughput", "update_time").Updates(m)
+	return result.Error
+}
+
+// GetReplicaUploadIDs returns ids of multipart uploads still referenced by replicas.
+func (i *ImageStorage) GetReplicaUploadIDs(target string) ([]string, error) {
+	var uploadIDs []string
+	result := i.db.WithContext(i.context).Model(&models.ImageReplica{}).
+		Where("target = ? AND upload_id <> ?", target, "").Pluck("upload_id", &uploadIDs)
+	return uploadIDs, result.Error
+}
+
+func (i *ImageStorage) GetReplicaByID(id int) (models.ImageReplica, error) {
+	var replica models.ImageReplica
+	result := i.db.WithContext(i.context).First(&replica, id)
+	return replica, result.Error
+}
+
+func (i *ImageStorage) GetReplicasByImageID(imageID int) ([]models.ImageReplica, error) {
+	var replicas []models.ImageReplica
+	result := i.db.WithContext(i.context).Where("image_id = ?", imageID).Order("id asc").Find(&replicas)
+	return replicas, result.Error
+}
+
+// MarkReplicasForRemoteCleanup schedules removal of objects of replicas which are not yet unpublished,
+// all targets are included when target is empty. Nothing is marked while any of the replicas is being pushed,
+// the number of those replicas is returned instead, since the push would leave objects behind.
+func (i *ImageStorage) MarkReplicasForRemoteCleanup(imageID int, target string) (int64, int64, error) {
+	var marked, pushing int64
+	err := i.db.WithContext(i.context).Transaction(func(tx *gorm.DB) error {
+		scope := func() *gorm.DB {
+			query := tx.Model(&models.ImageReplica{}).Where("image_id = ?", imageID)
+			if len(target) != 0 {
+				query = query.Where("target = ?", target)
+			}
+			return query
+		}
+		//locked rows hold pushers back from starting in between
+		var replicas []models.ImageReplica
+		if err := scope().Clauses(clause.Locking{Strength: "UPDATE"}).Find(&replicas).Error; err != nil {
+			return err
+		}
+		for _, replica := range replicas {
+			if replica.Status == models.ReplicaPushing {
+				pushing += 1
+			}
+		}
+		if pushing != 0 {
+			return nil
+		}
+		result := scope().Where("status IN ?", []models.ReplicaStatus{models.ReplicaPending, models.ReplicaPushed,
+			models.ReplicaFailed}).Updates(map[string]interface{}{
+			"status":        models.ReplicaRemoteCleanupPending,
+			"status_detail": "waiting for remote cleanup",
+			"attempts":      0,
+			"retry_time":    time.Now(),
+			"update_time":   time.Now(),
+		})
+		marked = result.RowsAffected
+		return result.Error
+	})
+	return marked, pushing, err
+}
+
+// CancelReplicas stops replicas of image waiting for or in push from being pushed.
+func (i *ImageStorage) CancelReplicas(imageID int) error {
+	result := i.db.WithContext(i.context).Model(&models.ImageReplica{}).
+		Where("image_id = ? AND status IN ?", imageID, []models.ReplicaStatus{models.ReplicaPending, models.ReplicaPushing}).
+		Updates(map[string]interface{}{
+			"status":        models.ReplicaFailed,
+			"status_detail": "push cancelled",
+			"retry_time":    nil,
+			"update_time":   time.Now(),
+		})
+	return result.Error
+}
+
+func (i *ImageStorage) DeleteReplicasByImageID(imageID int) error {
+	result := i.db.WithContext(i.context).Where("image_id = ?", imageID).Delete(&models.ImageReplica{})
+	return result.Error
+}
+
+// SummarizeReplicas saves the image status summarize derives from replicas of image, image row is locked so
+// that pushers of the same image finishing at once summarize one after another. summarize returns false to
+// keep image as it is, m is refreshed from database.
+func (i *ImageStorage) SummarizeReplicas(m *models.Image, summarize func(image *models.Image, replicas []models.ImageReplica) bool) (bool, error) {
+	changed := false
+	err := i.db.WithContext(i.context).Transaction(func(tx *gorm.DB) error {
+		var image models.Image
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&image, m.ID).Error; err != nil {
+			return err
+		}
+		var replicas []models.ImageReplica
+		if err := tx.Where("image_id = ?", m.ID).Find(&replicas).Error; err != nil {
+			return err
+		}
+		if !summarize(&image, replicas) {
+			*m = image
+			return nil
+		}
+		image.UpdateTime = time.Now()
+		err := tx.Model(&image).Select("status", "status_detail", "push_finish_time", "update_time").Updates(&image).Error
+		if err != nil {
+			return err
+		}
+		*m = image
+		changed = true
+		return nil
+	})
+	return changed, err
+}
+
+// GetPublishedImagesWithoutReplica returns images published before replicas were recorded.
+func (i *ImageStorage) GetPublishedImagesWithoutReplica(afterID, limit int) ([]models.Image, error) {
+	var images []models.Image
+	result := i.db.WithContext(i.context).Where("id > ? AND publish = ? AND deleted = ?", afterID, true, false).
+		Where("NOT EXISTS (SELECT 1 FROM image_replicas WHERE image_replicas.image_id = images.id)").
+		Order("id asc").Limit(limit).Find(&images)
+	return images, result.Error
+}
+
+// BackfillReplica saves replica derived for an image published before replicas were recorded along with the
+// restored local paths of image, nothing is saved when image got a replica meanwhile.
+func (i *ImageStorage) BackfillReplica(m *models.Image, replica *models.ImageReplica) (bool, error) {
+	created := false
+	err := i.db.WithContext(i.context).Transaction(func(tx *gorm.DB) error {
+		var image models.Image
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&image, m.ID).Error; err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.ImageReplica{}).Where("image_id = ?", m.ID).Count(&count).Error; err != nil || count != 0 {
+			return err
+		}
+		m.UpdateTime = time.Now()
+		if err := tx.Model(m).Select("image_path", "checksum_path", "update_time").Updates(m).Error; err != nil {
+			return err
+		}
+		replica.ImageID = m.ID
+		replica.CreateTime = time.Now()
+		replica.UpdateTime = time.Now()
+		if err := tx.Create(replica).Error; err != nil {
+			return err
+		}
+		created = true
+		return nil
+	})
+	return created, err
+}
@@ -38,6 +38,21 @@ func NewStore(config *config.PersistentStore, logger *zap.Logger) (*Store, error
 		logger.Error("failed to auto migrate image model")
 		return nil, err
 	}
+	err = database.AutoMigrate(models.ImageReplica{})
+	if err != nil {
+		logger.Error("failed to auto migrate image replica model")
+		return nil, err
+	}
+	err = database.AutoMigrate(models.Job{})
+	if err != nil {
+		logger.Error("failed to auto migrate job model")
+		return nil, err
+	}
+	err = database.AutoMigrate(models.WorkIntake{})
+	if err != nil {
+		logger.Error("failed to auto migrate work intake model")
+		return nil, err
+	}
 	return &Store{
 		Config:   config,
 		Logger:   logger,
@@ -0,0 +1,164 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/omnibuildplatform/omni-repository/common/config"
+	"github.com/omnibuildplatform/omni-repository/common/models"
+	"github.com/omnibuildplatform/omni-repository/common/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	CachePolicyNone         = "none"
+	CachePolicyAfterPublish = "afterPublish"
+	CachePolicyLRU          = "lru"
+)
+
+// CacheEvictor deletes local copies of published images, checksum files are kept since they are tiny.
+type CacheEvictor struct {
+	ImageStore  *storage.ImageStorage
+	Logger      *zap.Logger
+	Config      config.Cache
+	LocalFolder string
+}
+
+func NewCacheEvictor(config config.Cache, imageStore *storage.ImageStorage, logger *zap.Logger, localFolder string) (*CacheEvictor, error) {
+	switch config.Policy {
+	case CachePolicyNone, "", CachePolicyAfterPublish, CachePolicyLRU:
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported cache policy %s", config.Policy))
+	}
+	return &CacheEvictor{
+		ImageStore:  imageStore,
+		Logger:      logger,
+		Config:      config,
+		LocalFolder: localFolder,
+	}, nil
+}
+
+func (e *CacheEvictor) DoWork(ctx context.Context) error {
+	switch e.Config.Policy {
+	case CachePolicyAfterPublish:
+		images, err := e.ImageStore.GetImageForEviction(100)
+		if err != nil {
+			return err
+		}
+		for index := range images {
+			if _, err := e.evict(&images[index]); err != nil {
+				e.Logger.Error(fmt.Sprintf("failed to evict image %d, %v", images[index].ID, err))
+			}
+		}
+	case CachePolicyLRU:
+		for {
+			over, err := e.overHighWater()
+			if err != nil || !over {
+				return err
+			}
+			images, err := e.ImageStore.GetImageForEviction(20)
+			if err != nil {
+				return err
+			}
+			if len(images) == 0 {
+				e.Logger.Warn("cache is over high water mark while no published image can be evicted")
+				return nil
+			}
+			evicted := 0
+			for index := range images {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				done, err := e.evict(&images[index])
+				if err != nil {
+					e.Logger.Error(fmt.Sprintf("failed to evict image %d, %v", images[index].ID, err))
+					return err
+				}
+				if !done {
+					continue
+				}
+				evicted++
+				if over, err = e.overHighWater(); err != nil || !over {
+					return err
+				}
+			}
+			//the same images would come back on next pass, wait for next round instead of spinning
+			if evicted == 0 {
+				e.Logger.Warn("cache is over high water mark while none of the candidate images can be evicted")
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (e *CacheEvictor) overHighWater() (bool, error) {
+	if e.Config.MaxSize > 0 {
+		size, err := e.ImageStore.GetCachedImageSize()
+		if err != nil {
+			return false, err
+		}
+		if size > e.Config.MaxSize {
+			return true, nil
+		}
+	}
+	if e.Config.HighWaterPercent > 0 {
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(e.LocalFolder, &stat); err != nil {
+			return false, err
+		}
+		if stat.Blocks == 0 {
+			return false, nil
+		}
+		used := stat.Blocks - stat.Bavail
+		if used*100 > stat.Blocks*uint64(e.Config.HighWaterPercent) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// evict removes local copy of image when it's still pushed to at least one target, reports whether it was removed.
+func (e *CacheEvictor) evict(image *models.Image) (bool, error) {
+	unlock := lockImage(image.ID)
+	defer unlock()
+	latest, err := e.ImageStore.GetImageByID(image.ID)
+	if err != nil {
+		return false, err
+	}
+	if latest.Status != models.ImagePushed || latest.Evicted {
+		return false, nil
+	}
+	replicas, err := e.ImageStore.GetReplicasByImageID(image.ID)
+	if err != nil {
+		return false, err
+	}
+	published := false
+	for _, replica := range replicas {
+		if replica.Status == models.ReplicaPushed {
+			published = true
+			break
+		}
+	}
+	if !published {
+		return false, nil
+	}
+	//mark first, an evicted image with file left is simply re-fetched when requested
+	latest.Evicted = true
+	if err = e.ImageStore.UpdateImageCache(&latest); err != nil {
+		return false, err
+	}
+	image.Evicted = true
+	if err = os.Remove(path.Join(e.LocalFolder, latest.ImagePath)); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	e.Logger.Info(fmt.Sprintf("local copy of image %d evicted", image.ID))
+	return true, nil
+}
+
+func (e *CacheEvictor) Close() {
+}
@@ -0,0 +1,34 @@
+package workers
+
+import (
+	"fmt"
+
+	"github.com/omnibuildplatform/omni-repository/common/messages"
+	"github.com/omnibuildplatform/omni-repository/common/models"
+	"github.com/omnibuildplatform/omni-repository/common/storage"
+	"go.uber.org/zap"
+)
+
+// CancellableStatuses are the image statuses in which works can be cancelled.
+var CancellableStatuses = []models.ImageStatus{models.ImageCreated, models.ImageDownloading, models.ImageDownloaded,
+	models.ImageVerifying, models.ImageVerified, models.ImageScanning, models.ImageScanned, models.ImagePushing}
+
+func Cancellable(status models.ImageStatus) bool {
+	for _, s := range CancellableStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// markImageCancelled is called by workers after they have cleaned up on cancellation.
+func markImageCancelled(imageStore *storage.ImageStorage, image *models.Image, logger *zap.Logger, notifier messages.Notifier) {
+	image.Status = models.ImageCancelled
+	image.StatusDetail = "image works cancelled"
+	if err := imageStore.UpdateImageStatusAndDetail(image); err != nil {
+		logger.Error(fmt.Sprintf("failed to mark image %d cancelled, %v", image.ID, err))
+	}
+	notifier.NonBlockPush(string(models.ImageEventCancelled), image.ExternalComponent, image.ExternalID, map[string]interface{}{})
+	logger.Info(fmt.Sprintf("works of image %d cancelled", image.ID))
+}
@@ -0,0 +1,41 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/omnibuildplatform/omni-repository/common/models"
+	"github.com/omnibuildplatform/omni-repository/common/storage"
+	"go.uber.org/zap"
+)
+
+// ErrImageWithdrawn is returned by works whose image has been moved out of their stage by others, e.g.
+// cancelled, the work result is dropped.
+var ErrImageWithdrawn = errors.New("image has been moved out of the stage, e.g. cancelled")
+
+// transitImage moves image into status when it's still in one of expected statuses, so that works finishing
+// after their image is cancelled can't move it forward.
+func transitImage(imageStore *storage.ImageStorage, image *models.Image, status models.ImageStatus, detail string, expected ...models.ImageStatus) error {
+	previous, previousDetail := image.Status, image.StatusDetail
+	image.Status = status
+	image.StatusDetail = detail
+	ok, err := imageStore.TransitImageStatus(image, expected...)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		image.Status, image.StatusDetail = previous, previousDetail
+		return ErrImageWithdrawn
+	}
+	return nil
+}
+
+// checkpointImage moves image interrupted by shutdown from running status back to status from which its
+// work is resumed.
+func checkpointImage(imageStore *storage.ImageStorage, image *models.Image, running, status models.ImageStatus, detail string, logger *zap.Logger) {
+	if err := transitImage(imageStore, image, status, detail, running); err != nil {
+		logger.Error(fmt.Sprintf("failed to checkpoint image %d, %v", image.ID, err))
+		return
+	}
+	logger.Info(fmt.Sprintf("image %d checkpointed in status %s, %s", image.ID, status, detail))
+}
@@ -35,13 +35,42 @@ func (r *ImageCleaner) DoWork(ctx context.Context) error {
 	if err != nil {
 		r.Logger.Error(fmt.Sprintf("failed to clean up folder for image %s, %v", r.Image.ImagePath, err.Error()))
 	}
-	r.Notifier.NonBlockPush(string(models.ImageEventCleaned), r.Image.ExternalComponent, r.Image.ExternalID, map[string]interface{}{})
 	if r.Image.Deleted == true {
-		err := r.ImageStore.DeleteImageById(r.Image.ID)
+		//published objects are removed by unpublish works, image record is kept until they are all removed
+		marked, pushing, err := r.ImageStore.MarkReplicasForRemoteCleanup(r.Image.ID, "")
+		if err != nil {
+			r.Logger.Error(fmt.Sprintf("failed to schedule remote cleanup of image %s, %v", r.Image.ImagePath, err.Error()))
+			return err
+		}
+		if pushing != 0 {
+			//objects of running pushes are removed on next clean
+			r.Logger.Info(fmt.Sprintf("image %d is waiting for %d running pushes before remote cleanup", r.Image.ID, pushing))
+			return nil
+		}
+		replicas, err := r.ImageStore.GetReplicasByImageID(r.Image.ID)
+		if err != nil {
+			return err
+		}
+		for _, replica := range replicas {
+			if replica.Status == models.ReplicaRemoteCleanupPending {
+				if marked != 0 {
+					r.Logger.Info(fmt.Sprintf("image %d is waiting for remote cleanup on target %s", r.Image.ID, replica.Target))
+				}
+				return nil
+			}
+		}
+		r.Notifier.NonBlockPush(string(models.ImageEventCleaned), r.Image.ExternalComponent, r.Image.ExternalID, map[string]interface{}{})
+		err = r.ImageStore.DeleteReplicasByImageID(r.Image.ID)
+		if err != nil {
+			r.Logger.Error(fmt.Sprintf("failed to delete replica records of image %s, %v", r.Image.ImagePath, err.Error()))
+		}
+		err = r.ImageStore.DeleteImageById(r.Image.ID)
 		if err != nil {
 			r.Logger.Error(fmt.Sprintf("failed to hard delete image record %s, %v", r.Image.ImagePath, err.Error()))
 		}
+		return nil
 	}
+	r.Notifier.NonBlockPush(string(models.ImageEventCleaned), r.Image.ExternalComponent, r.Image.ExternalID, map[string]interface{}{})
 	return nil
 }
 
@@ -0,0 +1,90 @@
+package workers
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+type cancelledKey struct{}
+
+type imageContext struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	cancelled *atomic.Bool
+	refs      int
+}
+
+// ImageContexts keeps a cancellable context for each image with works in flight, works of the same image
+// share the context so that they are stopped together.
+type ImageContexts struct {
+	lock     sync.Mutex
+	contexts map[int]*imageContext
+}
+
+func NewImageContexts() *ImageContexts {
+	return &ImageContexts{
+		contexts: make(map[int]*imageContext),
+	}
+}
+
+// Acquire returns context of image derived from parent, release must be called when work finishes.
+func (i *ImageContexts) Acquire(parent context.Context, imageID int) (context.Context, func()) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	current, ok := i.contexts[imageID]
+	if !ok {
+		cancelled := atomic.NewBool(false)
+		ctx, cancel := context.WithCancel(context.WithValue(parent, cancelledKey{}, cancelled))
+		current = &imageContext{ctx: ctx, cancel: cancel, cancelled: cancelled}
+		i.contexts[imageID] = current
+	}
+	current.refs += 1
+	return current.ctx, func() {
+		i.lock.Lock()
+		defer i.lock.Unlock()
+		current.refs -= 1
+		if current.refs == 0 && i.contexts[imageID] == current {
+			delete(i.contexts, imageID)
+			current.cancel()
+		}
+	}
+}
+
+// Cancel stops works of image, false is returned when image has no work in flight.
+func (i *ImageContexts) Cancel(imageID int) bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	current, ok := i.contexts[imageID]
+	if !ok {
+		return false
+	}
+	//works acquired from now on get a fresh context
+	delete(i.contexts, imageID)
+	current.cancelled.Store(true)
+	current.cancel()
+	return true
+}
+
+func (i *ImageContexts) ImageIDs() []int {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	ids := make([]int, 0, len(i.contexts))
+	for id := range i.contexts {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// IsInterrupted tells whether work is stopped by shutdown, interrupted works are checkpointed and resumed
+// after restart.
+func IsInterrupted(ctx context.Context) bool {
+	return ctx.Err() != nil && !IsCancelled(ctx)
+}
+
+// IsCancelled tells whether works of image are cancelled on purpose, rather than stopped by shutdown.
+func IsCancelled(ctx context.Context) bool {
+	cancelled, ok := ctx.Value(cancelledKey{}).(*atomic.Bool)
+	return ok && cancelled.Load() && ctx.Err() != nil
+}
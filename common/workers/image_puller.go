@@ -30,6 +30,16 @@ const MaxTempFileSize = 100 * 1024 * 1024
 const TempFolder = ".temp"
 const UnReachableBlock = 100
 
+// SourceError tells the source of image can't be pulled as it is, e.g. it's missing, forbidden or malformed,
+// pulling again won't help before source is fixed.
+type SourceError struct {
+	Detail string
+}
+
+func (e *SourceError) Error() string {
+	return e.Detail
+}
+
 type SingleBlock struct {
 	Index      string
 	StartIndex int64
@@ -47,10 +57,11 @@ type ImagePuller struct {
 	Config       config.ImagePuller
 	Worker       int
 	ImageSize    int
+	Policy       config.FilePolicy
 	Notifier     messages.Notifier
 }
 
-func NewImagePuller(config config.ImagePuller, imageStore *storage.ImageStorage, logger *zap.Logger, image *models.Image, localFolder string, worker int, notifier messages.Notifier) (*ImagePuller, error) {
+func NewImagePuller(config config.ImagePuller, imageStore *storage.ImageStorage, logger *zap.Logger, image *models.Image, localFolder string, worker int, policy config.FilePolicy, notifier messages.Notifier) (*ImagePuller, error) {
 	client := http.Client{
 		Timeout: 60 * 20 * time.Second,
 	}
@@ -63,17 +74,29 @@ func NewImagePuller(config config.ImagePuller, imageStore *storage.ImageStorage,
 		Client:       client,
 		BlockChannel: make(chan SingleBlock, 100),
 		Worker:       worker,
+		Policy:       policy,
 		Notifier:     notifier,
 	}, nil
 }
 
-func (r *ImagePuller) cleanup(err error) {
+func (r *ImagePuller) cleanup(ctx context.Context, err error) {
+	if IsInterrupted(ctx) {
+		//downloaded blocks are skipped when download is resumed
+		checkpointImage(r.ImageStore, r.Image, models.ImageDownloading, models.ImageCreated, "download interrupted by shutdown, will be resumed", r.Logger)
+		return
+	}
 	blockTempFolder := path.Join(r.LocalFolder, TempFolder)
 	_ = os.RemoveAll(blockTempFolder)
-	r.Image.Status = models.ImageFailed
-	r.Image.StatusDetail = err.Error()
-	_ = r.ImageStore.UpdateImageStatusAndDetail(r.Image)
-
+	if IsCancelled(ctx) {
+		//partial image file is useless once download is cancelled
+		_ = os.Remove(path.Join(r.LocalFolder, r.Image.FileName))
+		markImageCancelled(r.ImageStore, r.Image, r.Logger, r.Notifier)
+		return
+	}
+	if transitErr := transitImage(r.ImageStore, r.Image, models.ImageFailed, err.Error(), models.ImageDownloading); transitErr != nil {
+		r.Logger.Warn(fmt.Sprintf("image %d not marked failed, %v", r.Image.ID, transitErr))
+		return
+	}
 	//send failed message
 	r.Notifier.NonBlockPush(string(models.ImageEventFailed), r.Image.ExternalComponent, r.Image.ExternalID, map[string]interface{}{
 		"detail": err.Error(),
@@ -89,8 +112,15 @@ func (r *ImagePuller) DoWork(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	r.Image.Status = models.ImageDownloading
-	err = r.ImageStore.UpdateImageStatus(r.Image)
+	err = transitImage(r.ImageStore, r.Image, models.ImageDownloading, r.Image.StatusDetail,
+		models.ImageCreated, models.ImageDownloading, models.ImageFailed)
+	if err != nil {
+		return err
+	}
+	startTime := time.Now()
+	r.Image.DownloadStartTime = &startTime
+	r.Image.DownloadFinishTime = nil
+	err = r.ImageStore.UpdateImageStageTime(r.Image)
 	if err != nil {
 		return err
 	}
@@ -111,31 +141,49 @@ func (r *ImagePuller) DoWork(ctx context.Context) error {
 	if err != nil {
 		close(r.BlockChannel)
 		wg.Wait()
-		r.cleanup(err)
+		r.cleanup(ctx, err)
 		return err
 	}
 	r.Logger.Info(fmt.Sprintf("image %s will be downloaded in %d parts in parallel", r.Image.SourceUrl, size))
+	r.Image.Size = int64(r.ImageSize)
+	if err = r.ImageStore.UpdateImageFileInfo(r.Image); err != nil {
+		r.Logger.Error(fmt.Sprintf("failed to update size of image %s, %v", r.Image.SourceUrl, err))
+	}
 	totalBlocks.Add(int32(size))
 	totalBlocks.Sub(UnReachableBlock)
 	wg.Wait()
+	if ctx.Err() != nil {
+		r.cleanup(ctx, ctx.Err())
+		return ctx.Err()
+	}
 	files, err := ioutil.ReadDir(blockTempFolder)
-	if len(files) != size {
-		r.cleanup(err)
+	if err == nil && len(files) != size {
+		err = errors.New(fmt.Sprintf("only %d of %d blocks of image %s downloaded", len(files), size, r.Image.SourceUrl))
+	}
+	if err != nil {
+		r.cleanup(ctx, err)
 		return err
 	}
 	// 4. combine result
 	err = r.ConstructImageFile()
 	if err != nil {
-		r.cleanup(err)
+		r.cleanup(ctx, err)
 		return err
 	}
 
 	r.Logger.Info(fmt.Sprintf("image %s successfully created.", r.Image.SourceUrl))
-	r.Image.Status = models.ImageDownloaded
-	r.Image.StatusDetail = "image successfully downloaded"
-	err = r.ImageStore.UpdateImageStatusAndDetail(r.Image)
-	if err != nil {
-		r.cleanup(err)
+	finishTime := time.Now()
+	r.Image.DownloadFinishTime = &finishTime
+	if err = r.ImageStore.UpdateImageStageTime(r.Image); err != nil {
+		r.cleanup(ctx, err)
+		return err
+	}
+	err = transitImage(r.ImageStore, r.Image, models.ImageDownloaded, "image successfully downloaded", models.ImageDownloading)
+	if errors.Is(err, ErrImageWithdrawn) {
+		_ = os.RemoveAll(blockTempFolder)
+		return err
+	} else if err != nil {
+		r.cleanup(ctx, err)
 		return err
 	}
 	_ = os.RemoveAll(blockTempFolder)
@@ -193,14 +241,14 @@ func (r *ImagePuller) downloadPrepare(ctx context.Context, wg *sync.WaitGroup) (
 	defer wg.Done()
 	rawUrl, err := url.Parse(r.Image.SourceUrl)
 	if err != nil {
-		return 0, err
+		return 0, &SourceError{Detail: fmt.Sprintf("malformed source url %s, %v", r.Image.SourceUrl, err)}
 	}
 	if rawUrl.Scheme != "http" && rawUrl.Scheme != "https" {
-		return 0, errors.New(fmt.Sprintf("source url schema not supported, %s", rawUrl.Scheme))
+		return 0, &SourceError{Detail: fmt.Sprintf("source url schema not supported, %s", rawUrl.Scheme)}
 	}
 	request, err := http.NewRequest("HEAD", r.Image.SourceUrl, nil)
 	if err != nil {
-		return 0, errors.New(fmt.Sprintf("failed to construct request for source url, %s", r.Image.SourceUrl))
+		return 0, &SourceError{Detail: fmt.Sprintf("failed to construct request for source url, %s", r.Image.SourceUrl)}
 	}
 	request = request.WithContext(ctx)
 	// curl the pop star, we have to
@@ -210,19 +258,28 @@ func (r *ImagePuller) downloadPrepare(ctx context.Context, wg *sync.WaitGroup) (
 		return 0, err
 	}
 	if result.StatusCode != http.StatusOK {
-		return 0, errors.New(fmt.Sprintf("unacceptable status code %d when HEAD image meta %s",
+		detail := fmt.Sprintf("unacceptable status code %d when HEAD image meta %s",
 			result.StatusCode,
-			r.Image.SourceUrl))
+			r.Image.SourceUrl)
+		//client errors stay until source is fixed, except for timeouts and throttling
+		if result.StatusCode >= 400 && result.StatusCode < 500 &&
+			result.StatusCode != http.StatusRequestTimeout && result.StatusCode != http.StatusTooManyRequests {
+			return 0, &SourceError{Detail: detail}
+		}
+		return 0, errors.New(detail)
 	}
 	if len(result.Header.Get("content-length")) == 0 {
-		return 0, errors.New(fmt.Sprintf("unacceptable content type %s or content length empty %s image %s",
+		return 0, &SourceError{Detail: fmt.Sprintf("unacceptable content type %s or content length empty %s image %s",
 			result.Header.Get("content-type"),
 			result.Header.Get("content-length"),
-			r.Image.SourceUrl))
+			r.Image.SourceUrl)}
 	}
 	r.ImageSize, err = strconv.Atoi(result.Header.Get("content-length"))
 	if err != nil {
-		return 0, errors.New(fmt.Sprintf("unaccptable content length %s for image %s", result.Header.Get("content-length"), r.Image.SourceUrl))
+		return 0, &SourceError{Detail: fmt.Sprintf("unaccptable content length %s for image %s", result.Header.Get("content-length"), r.Image.SourceUrl)}
+	}
+	if err = checkPolicySize(r.Policy, r.Image.ExternalComponent, int64(r.ImageSize)); err != nil {
+		return 0, err
 	}
 
 	var blocks []SingleBlock
@@ -240,7 +297,11 @@ func (r *ImagePuller) downloadPrepare(ctx context.Context, wg *sync.WaitGroup) (
 	totalBlocks := len(blocks)
 	for index, b := range blocks {
 		b.Index = fmt.Sprintf("%d/%d", index+1, totalBlocks)
-		r.BlockChannel <- b
+		select {
+		case r.BlockChannel <- b:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
 	}
 	return len(blocks), nil
 }
@@ -250,6 +311,9 @@ func (r *ImagePuller) startWorkerLoop(ctx context.Context, wg *sync.WaitGroup, t
 	finishTicker := time.NewTicker(5 * time.Second)
 	for {
 		select {
+		case <-ctx.Done():
+			r.Logger.Info(fmt.Sprintf("image puller for image %s stopped, %v", r.Image.FileName, ctx.Err()))
+			return
 		case <-finishTicker.C:
 			//NOTE: when all task finished no matter success or fail, break loop
 			if totalBlocks.Load() == 0 {
@@ -325,7 +389,7 @@ func (r *ImagePuller) fetchSingleBlock(ctx context.Context, block SingleBlock) e
 		return err
 	}
 	defer blockFile.Close()
-	_, err = io.Copy(blockFile, result.Body)
+	_, err = io.Copy(blockFile, newThrottledReader(ctx, result.Body, 0))
 	//validate new file size
 	if info, err := os.Stat(fileName); err != nil {
 		return err
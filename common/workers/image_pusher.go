@@ -2,45 +2,42 @@ package workers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/huaweicloud/huaweicloud-sdk-go-obs/obs"
 	"github.com/omnibuildplatform/omni-repository/common/config"
 	"github.com/omnibuildplatform/omni-repository/common/messages"
 	"github.com/omnibuildplatform/omni-repository/common/models"
+	"github.com/omnibuildplatform/omni-repository/common/objectstore"
 	"github.com/omnibuildplatform/omni-repository/common/storage"
 	"go.uber.org/zap"
 	"os"
 	"path"
+	"sort"
 	"strings"
+	"time"
 )
 
-const NotFoundError = "Status=404 Not Found"
-
 type ImagePusher struct {
 	imageStore  *storage.ImageStorage
 	Image       *models.Image
+	Replica     *models.ImageReplica
 	LocalFolder string
 	Logger      *zap.Logger
 	Config      config.ImagePusher
-	OBSClient   *obs.ObsClient
+	ObjectStore objectstore.ObjectStore
 	Worker      int
 	Notifier    messages.Notifier
 }
 
-func NewImagePusher(config config.ImagePusher, imageStore *storage.ImageStorage, image *models.Image, localFolder string, logger *zap.Logger, worker int, notifier messages.Notifier) (*ImagePusher, error) {
-	if len(config.AK) == 0 || len(config.SK) == 0 || len(config.Endpoint) == 0 {
-		return nil, errors.New("incorrect ak/sk/endpoint config for image pusher")
-	}
-	obsClient, err := obs.New(config.AK, config.SK, fmt.Sprintf("https://%s", config.Endpoint))
-	if err != nil {
-		return nil, err
-
+func NewImagePusher(config config.ImagePusher, imageStore *storage.ImageStorage, image *models.Image, replica *models.ImageReplica, localFolder string, logger *zap.Logger, worker int, notifier messages.Notifier) (*ImagePusher, error) {
+	target, ok := config.Targets[replica.Target]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("publish target %s not configured", replica.Target))
 	}
-	// check bucket whether exists
-	_, err = obsClient.GetBucketLocation(config.Bucket)
+	store, err := objectstore.NewObjectStore(target)
 	if err != nil {
-		logger.Error(fmt.Sprintf("failed to get bucket information %s", config.Bucket))
+		logger.Error(fmt.Sprintf("failed to initialize object store for target %s, %v", replica.Target, err))
 		return nil, err
 	}
 
@@ -48,103 +45,260 @@ func NewImagePusher(config config.ImagePusher, imageStore *storage.ImageStorage,
 		Config:      config,
 		imageStore:  imageStore,
 		Image:       image,
-		Logger:      logger,
+		Replica:     replica,
 		LocalFolder: localFolder,
-		OBSClient:   obsClient,
+		Logger:      logger,
+		ObjectStore: store,
 		Worker:      worker,
 		Notifier:    notifier,
 	}, nil
 }
 
-func (r *ImagePusher) cleanup(err error) {
-	r.Image.Status = models.ImageFailed
-	r.Image.StatusDetail = err.Error()
-	_ = r.imageStore.UpdateImageStatusAndDetail(r.Image)
-	r.Notifier.NonBlockPush(string(models.ImageEventFailed), r.Image.ExternalComponent, r.Image.ExternalID, map[string]interface{}{
-		"detail": err.Error(),
+// cleanup marks replica failed, replica will be retried with exponential backoff until max retry reached.
+// Cancelled pushes are not retried and their uploads are aborted, pushes interrupted by shutdown keep their
+// uploads and are resumed.
+func (r *ImagePusher) cleanup(ctx context.Context, err error) {
+	if IsInterrupted(ctx) {
+		if updateErr := r.imageStore.UpdateReplicaUpload(r.Replica); updateErr != nil {
+			r.Logger.Error(fmt.Sprintf("failed to checkpoint upload of replica %d, %v", r.Replica.ID, updateErr))
+		}
+		r.Replica.Status = models.ReplicaPending
+		r.Replica.StatusDetail = "push interrupted by shutdown, will be resumed"
+		_ = r.transitReplica(models.ReplicaPushing)
+		r.Logger.Info(fmt.Sprintf("push of image %d to target %s checkpointed, %d of %d bytes uploaded",
+			r.Image.ID, r.Replica.Target, r.Replica.UploadedBytes, r.Replica.TotalBytes))
+		return
+	}
+	if IsCancelled(ctx) {
+		if len(r.Replica.UploadID) != 0 {
+			r.abortUpload(context.Background(), r.Replica.UploadKey, r.Replica.UploadID)
+		}
+		r.Replica.Status = models.ReplicaFailed
+		r.Replica.StatusDetail = "push cancelled"
+		r.Replica.RetryTime = nil
+		_ = r.imageStore.UpdateReplica(r.Replica)
+		markImageCancelled(r.imageStore, r.Image, r.Logger, r.Notifier)
+		return
+	}
+	r.Replica.Status = models.ReplicaFailed
+	r.Replica.StatusDetail = err.Error()
+	r.Replica.Attempts += 1
+	r.Replica.RetryTime = nil
+	if r.Replica.Attempts < r.Config.MaxRetry {
+		retryTime := time.Now().Add(time.Duration(r.Config.RetryInterval) * time.Second * time.Duration(1<<(r.Replica.Attempts-1)))
+		r.Replica.RetryTime = &retryTime
+		r.Logger.Info(fmt.Sprintf("push image %d to target %s will have another try at %s",
+			r.Image.ID, r.Replica.Target, retryTime.Format(time.RFC3339)))
+	} else if len(r.Replica.UploadID) != 0 {
+		//no more retry, the interrupted upload will never be resumed
+		r.abortUpload(context.Background(), r.Replica.UploadKey, r.Replica.UploadID)
+	}
+	if transitErr := r.transitReplica(models.ReplicaPushing); transitErr != nil {
+		r.Logger.Warn(fmt.Sprintf("replica %d not marked failed, %v", r.Replica.ID, transitErr))
+		return
+	}
+	_ = r.refreshImageStatus()
+}
+
+// pushableStatuses are the image statuses from which push starts.
+var pushableStatuses = []models.ImageStatus{models.ImageVerified, models.ImageScanned, models.ImagePushing,
+	models.ImagePushed, models.ImageFailed}
+
+// transitReplica saves replica when it's still in one of expected statuses, replicas cancelled meanwhile are
+// not moved forward.
+func (r *ImagePusher) transitReplica(expected ...models.ReplicaStatus) error {
+	ok, err := r.imageStore.TransitReplica(r.Replica, expected...)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrImageWithdrawn
+	}
+	return nil
+}
+
+// refreshImageStatus summaries status of all replicas into image status, image is pushed when all replicas are
+// pushed and failed when any replica failed without retry. Images moved out of push stage meanwhile, e.g.
+// cancelled, are kept as they are.
+func (r *ImagePusher) refreshImageStatus() error {
+	var failedTargets []string
+	changed, err := r.imageStore.SummarizeReplicas(r.Image, func(image *models.Image, replicas []models.ImageReplica) bool {
+		if image.Status != models.ImagePushing && image.Status != models.ImagePushed && image.Status != models.ImageFailed {
+			return false
+		}
+		failedTargets = nil
+		for _, replica := range replicas {
+			if replica.Status == models.ReplicaUnpublished {
+				//withdrawn on purpose
+				continue
+			}
+			if replica.Status == models.ReplicaFailed && replica.RetryTime == nil {
+				failedTargets = append(failedTargets, replica.Target)
+			} else if replica.Status != models.ReplicaPushed {
+				//still in progress
+				return false
+			}
+		}
+		if len(failedTargets) != 0 {
+			image.Status = models.ImageFailed
+			image.StatusDetail = fmt.Sprintf("failed to push image to targets %s", strings.Join(failedTargets, ","))
+			return true
+		}
+		finishTime := time.Now()
+		image.PushFinishTime = &finishTime
+		image.Status = models.ImagePushed
+		image.StatusDetail = fmt.Sprintf("image pushed to %d targets", len(replicas))
+		return true
 	})
+	if err != nil || !changed {
+		return err
+	}
+	if r.Image.Status == models.ImageFailed {
+		r.Notifier.NonBlockPush(string(models.ImageEventFailed), r.Image.ExternalComponent, r.Image.ExternalID, map[string]interface{}{
+			"detail": r.Image.StatusDetail,
+		})
+	}
+	return nil
 }
 
 func (r *ImagePusher) DoWork(ctx context.Context) error {
-	r.Image.Status = models.ImagePushing
-	err := r.imageStore.UpdateImageStatus(r.Image)
-	if err != nil {
+	var err error
+	if r.Image.Status != models.ImagePushing {
+		err = transitImage(r.imageStore, r.Image, models.ImagePushing, r.Image.StatusDetail, pushableStatuses...)
+		if err != nil {
+			return err
+		}
+		startTime := time.Now()
+		r.Image.PushStartTime = &startTime
+		r.Image.PushFinishTime = nil
+		err = r.imageStore.UpdateImageStageTime(r.Image)
+		if err != nil {
+			return err
+		}
+	}
+	r.Replica.Status = models.ReplicaPushing
+	if err = r.transitReplica(models.ReplicaPending, models.ReplicaPushing, models.ReplicaFailed); err != nil {
 		return err
 	}
+	if r.Image.Evicted {
+		if err = RehydrateImage(ctx, r.Config, r.imageStore, r.Image, r.LocalFolder, r.Logger); err != nil {
+			r.cleanup(ctx, err)
+			return err
+		}
+	}
 	//1. create folder
-	folderKey := fmt.Sprintf("%d/%s/", r.Image.UserId, r.Image.Checksum)
-	if err = r.createFolderIfNeeded(folderKey); err != nil {
-		r.cleanup(err)
+	folderKey, checksumName, imageKey := ImageObjectKeys(r.Image)
+	if err = r.createFolderIfNeeded(ctx, folderKey); err != nil {
+		r.cleanup(ctx, err)
 		return err
 	}
 	//2. create image checksum object
-	checksumNames := strings.Split(r.Image.ChecksumPath, "/")
-	checksumName := fmt.Sprintf("%s%s", folderKey, checksumNames[len(checksumNames)-1])
-	if exists, err := r.objectExists(checksumName); err != nil {
+	if exists, err := r.objectExists(ctx, checksumName); err != nil {
+		r.cleanup(ctx, err)
 		return err
 	} else if exists {
-		r.Logger.Info(fmt.Sprintf("found existing file %s on obs will delete first", checksumName))
-		err = r.deleteObject(checksumName)
+		r.Logger.Info(fmt.Sprintf("found existing file %s on target %s will delete first", checksumName, r.Replica.Target))
+		err = r.deleteObject(ctx, checksumName)
 		if err != nil {
-			r.cleanup(err)
+			r.cleanup(ctx, err)
 			return err
 		}
 	}
-	err = r.concurrentPushObject(path.Join(r.LocalFolder, r.Image.ChecksumPath), checksumName)
+	err = r.putObject(ctx, path.Join(r.LocalFolder, r.Image.ChecksumPath), checksumName)
 	if err != nil {
-		r.Logger.Error(fmt.Sprintf("failed to upload image file %s %v", checksumName, err))
-		r.cleanup(err)
+		r.Logger.Error(fmt.Sprintf("failed to upload checksum file %s %v", checksumName, err))
+		r.cleanup(ctx, err)
 		return err
 	}
 	//3. create image object
-	imageNames := strings.Split(r.Image.ImagePath, "/")
-	imageKey := fmt.Sprintf("%s%s", folderKey, imageNames[len(imageNames)-1])
-	if exists, err := r.objectExists(imageKey); err != nil {
-		r.cleanup(err)
+	if exists, err := r.objectExists(ctx, imageKey); err != nil {
+		r.cleanup(ctx, err)
 		return err
 	} else if exists {
-		r.Logger.Info(fmt.Sprintf("found existing file %s on obs will delete first", imageKey))
-		err = r.deleteObject(imageKey)
+		r.Logger.Info(fmt.Sprintf("found existing file %s on target %s will delete first", imageKey, r.Replica.Target))
+		err = r.deleteObject(ctx, imageKey)
 		if err != nil {
-			r.cleanup(err)
+			r.cleanup(ctx, err)
 			return err
 		}
 	}
-	err = r.concurrentPushObject(path.Join(r.LocalFolder, r.Image.ImagePath), imageKey)
+	err = r.concurrentPushObject(ctx, path.Join(r.LocalFolder, r.Image.ImagePath), imageKey)
 	if err != nil {
 		r.Logger.Error(fmt.Sprintf("failed to upload image file %s %v", imageKey, err))
-		r.cleanup(err)
+		r.cleanup(ctx, err)
 		return err
 	}
-	//4. update object status and link
-	r.Image.Status = models.ImagePushed
-	err = r.imageStore.UpdateImageStatus(r.Image)
+	//4. verify what landed on target
+	err = r.VerifyRemote(ctx)
 	if err != nil {
-		r.cleanup(err)
+		r.Logger.Error(fmt.Sprintf("failed to verify objects of image %d on target %s %v", r.Image.ID, r.Replica.Target, err))
+		r.cleanup(ctx, err)
 		return err
 	}
-	r.Image.ImagePath = fmt.Sprintf("https://%s.%s/%s", r.Config.Bucket, r.Config.Endpoint, strings.TrimLeft(r.Image.ImagePath, "/"))
-	r.Image.ChecksumPath = fmt.Sprintf("https://%s.%s/%s", r.Config.Bucket, r.Config.Endpoint, strings.TrimLeft(r.Image.ChecksumPath, "/"))
-	err = r.imageStore.UpdateImageExternalPath(r.Image)
-	if err != nil {
-		r.cleanup(err)
+	//5. update replica status and link
+	r.Replica.Status = models.ReplicaPushed
+	r.Replica.StatusDetail = "image pushed"
+	r.Replica.ImageUrl = r.ObjectStore.PublicURL(imageKey)
+	r.Replica.ChecksumUrl = r.ObjectStore.PublicURL(checksumName)
+	r.Replica.RetryTime = nil
+	err = r.transitReplica(models.ReplicaPushing)
+	if errors.Is(err, ErrImageWithdrawn) {
+		return err
+	} else if err != nil {
+		r.cleanup(ctx, err)
 		return err
 	}
 	r.Notifier.NonBlockPush(string(models.ImageEventPushed), r.Image.ExternalComponent, r.Image.ExternalID, map[string]interface{}{
-		"imagePath":    r.Image.ImagePath,
-		"checksumPath": r.Image.ChecksumPath,
+		"target":       r.Replica.Target,
+		"imagePath":    r.Replica.ImageUrl,
+		"checksumPath": r.Replica.ChecksumUrl,
+		"throughput":   r.Replica.Throughput,
 	})
-	return nil
+	return r.refreshImageStatus()
+}
+
+// ImageObjectKeys returns keys of folder marker, checksum and image objects of image on publish targets.
+func ImageObjectKeys(image *models.Image) (string, string, string) {
+	folderKey := fmt.Sprintf("%d/%s/", image.UserId, image.Checksum)
+	return folderKey, fmt.Sprintf("%s%s", folderKey, path.Base(image.ChecksumPath)),
+		fmt.Sprintf("%s%s", folderKey, path.Base(image.ImagePath))
 }
 
-func (r *ImagePusher) createFolderIfNeeded(name string) error {
-	if exists, err := r.objectExists(name); err != nil {
-		r.Logger.Error(fmt.Sprintf("failed to check obs folder existence for %s", name))
+// VerifyRemote compares the checksum and image objects on target with the local files.
+func (r *ImagePusher) VerifyRemote(ctx context.Context) error {
+	if r.Image.Evicted {
+		if err := RehydrateImage(ctx, r.Config, r.imageStore, r.Image, r.LocalFolder, r.Logger); err != nil {
+			return err
+		}
+	}
+	_, checksumName, imageKey := ImageObjectKeys(r.Image)
+	err := verifyRemoteObject(ctx, r.ObjectStore, checksumName, path.Join(r.LocalFolder, r.Image.ChecksumPath), r.Config.PartSize, false)
+	if err != nil {
+		return err
+	}
+	return verifyRemoteObject(ctx, r.ObjectStore, imageKey, path.Join(r.LocalFolder, r.Image.ImagePath), r.Config.PartSize, true)
+}
+
+// FailVerification marks a pushed replica failed without retry, image is marked failed as well. Replicas
+// moved out of pushed meanwhile, e.g. being pushed again, are kept as they are.
+func (r *ImagePusher) FailVerification(err error) error {
+	r.Replica.Status = models.ReplicaFailed
+	r.Replica.StatusDetail = err.Error()
+	r.Replica.RetryTime = nil
+	if transitErr := r.transitReplica(models.ReplicaPushed); transitErr != nil {
+		return transitErr
+	}
+	return r.refreshImageStatus()
+}
+
+func (r *ImagePusher) createFolderIfNeeded(ctx context.Context, name string) error {
+	if exists, err := r.objectExists(ctx, name); err != nil {
+		r.Logger.Error(fmt.Sprintf("failed to check folder existence for %s", name))
 		return err
 	} else if !exists {
-		err = r.createSingleLevelFolder(name)
+		err = r.createSingleLevelFolder(ctx, name)
 		if err != nil {
-			r.Logger.Error(fmt.Sprintf("failed to create obs folder for %s", name))
+			r.Logger.Error(fmt.Sprintf("failed to create folder for %s", name))
 			return err
 		}
 	}
@@ -155,13 +309,10 @@ func (r *ImagePusher) createFolderIfNeeded(name string) error {
 func (r *ImagePusher) Close() {
 }
 
-func (r *ImagePusher) objectExists(path string) (bool, error) {
-	input := &obs.GetObjectMetadataInput{}
-	input.Bucket = r.Config.Bucket
-	input.Key = path
-	_, err := r.OBSClient.GetObjectMetadata(input)
+func (r *ImagePusher) objectExists(ctx context.Context, path string) (bool, error) {
+	_, err := r.ObjectStore.Stat(ctx, path)
 	if err != nil {
-		if strings.Contains(err.Error(), NotFoundError) {
+		if errors.Is(err, os.ErrNotExist) {
 			return false, nil
 		}
 		return false, err
@@ -169,11 +320,8 @@ func (r *ImagePusher) objectExists(path string) (bool, error) {
 	return true, nil
 }
 
-func (r *ImagePusher) createSingleLevelFolder(path string) error {
-	var input = &obs.PutObjectInput{}
-	input.Bucket = r.Config.Bucket
-	input.Key = fmt.Sprintf("%s/", strings.TrimRight(path, "/"))
-	_, err := r.OBSClient.PutObject(input)
+func (r *ImagePusher) createSingleLevelFolder(ctx context.Context, path string) error {
+	err := r.ObjectStore.Put(ctx, fmt.Sprintf("%s/", strings.TrimRight(path, "/")), strings.NewReader(""), 0)
 	if err != nil {
 		r.Logger.Error(fmt.Sprintf("failed to create single level folder %s ", path))
 		return err
@@ -181,17 +329,27 @@ func (r *ImagePusher) createSingleLevelFolder(path string) error {
 	return nil
 }
 
-func (r *ImagePusher) concurrentPushObject(localPath, name string) error {
-	input := &obs.InitiateMultipartUploadInput{}
-	input.Bucket = r.Config.Bucket
-	input.Key = name
-	output, err := r.OBSClient.InitiateMultipartUpload(input)
+// putObject uploads small file in a single request.
+func (r *ImagePusher) putObject(ctx context.Context, localPath, name string) error {
+	file, err := os.Open(localPath)
 	if err != nil {
 		return err
 	}
-	uploadId := output.UploadId
-	r.Logger.Info(fmt.Sprintf("Claiming a new upload id %s for file %s", uploadId, name))
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err = r.ObjectStore.Put(ctx, name, file, stat.Size()); err != nil {
+		return err
+	}
+	AddProgress(ctx, stat.Size())
+	return nil
+}
 
+// concurrentPushObject uploads file in multipart, the upload id and completed parts are persisted on replica so that
+// an interrupted push only uploads the missing parts, the upload is aborted when push failed.
+func (r *ImagePusher) concurrentPushObject(ctx context.Context, localPath, name string) error {
 	// Calculate how many blocks to be divided into small blocks, for example, 20MB, 419430400
 	partSize := r.Config.PartSize
 	stat, err := os.Stat(localPath)
@@ -199,78 +357,247 @@ func (r *ImagePusher) concurrentPushObject(localPath, name string) error {
 		return err
 	}
 	fileSize := stat.Size()
+	if fileSize == 0 {
+		//multipart upload can not be completed without parts
+		r.Logger.Info(fmt.Sprintf("file %s is empty, uploaded in a single request", name))
+		return r.putObject(ctx, localPath, name)
+	}
 	partCount := int(fileSize / partSize)
 	if fileSize%partSize != 0 {
 		partCount++
 	}
 	r.Logger.Info(fmt.Sprintf("file %s will be devided into %d parts", name, partCount))
 
-	partChan := make(chan obs.Part, r.Worker)
+	uploadId, completed, err := r.prepareUpload(ctx, name, partSize, fileSize, partCount)
+	if err != nil {
+		return err
+	}
 
-	for i := 0; i < partCount; i++ {
-		partNumber := i + 1
-		offset := int64(i) * partSize
-		currPartSize := partSize
-		if i+1 == partCount {
-			currPartSize = fileSize - offset
+	var missing []int
+	for partNumber := 1; partNumber <= partCount; partNumber++ {
+		if _, ok := completed[partNumber]; !ok {
+			missing = append(missing, partNumber)
 		}
-		go func(index int, offset, partSize int64, logger *zap.Logger) {
-			uploadPartInput := &obs.UploadPartInput{}
-			uploadPartInput.Bucket = r.Config.Bucket
-			uploadPartInput.Key = name
-			uploadPartInput.UploadId = uploadId
-			uploadPartInput.SourceFile = localPath
-			uploadPartInput.PartNumber = index
-			uploadPartInput.Offset = offset
-			uploadPartInput.PartSize = partSize
-			logger.Info(fmt.Sprintf("starting to upload block %d.", index))
-			uploadPartInputOutput, errMsg := r.OBSClient.UploadPart(uploadPartInput)
-			if errMsg == nil {
-				logger.Info(fmt.Sprintf("upload block %d finished", index))
-				partChan <- obs.Part{ETag: uploadPartInputOutput.ETag, PartNumber: uploadPartInputOutput.PartNumber}
-			} else {
-				logger.Error(fmt.Sprintf("upload block %d failed with error %v", index, err))
-				partChan <- obs.Part{ETag: "fake etag", PartNumber: -1}
+	}
+	r.Replica.TotalBytes = fileSize
+	r.Replica.UploadedBytes = 0
+	for _, p := range completed {
+		r.Replica.UploadedBytes += p.Size
+	}
+	r.Replica.Throughput = 0
+
+	// parts are uploaded by a bounded number of workers, the rest parts are skipped once any part failed
+	partCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	partChan := make(chan int, len(missing))
+	for _, partNumber := range missing {
+		partChan <- partNumber
+	}
+	close(partChan)
+	type partResult struct {
+		part objectstore.Part
+		err  error
+	}
+	resultChan := make(chan partResult, len(missing))
+	poolSize := r.Worker
+	if poolSize <= 0 {
+		poolSize = 1
+	}
+	if poolSize > len(missing) {
+		poolSize = len(missing)
+	}
+	for i := 0; i < poolSize; i++ {
+		go func() {
+			for index := range partChan {
+				if partCtx.Err() != nil {
+					resultChan <- partResult{err: partCtx.Err()}
+					continue
+				}
+				offset, currPartSize := partRange(index, partSize, fileSize)
+				part, err := r.uploadPartWithRetry(partCtx, name, uploadId, index, localPath, offset, currPartSize)
+				resultChan <- partResult{part: part, err: err}
 			}
-		}(partNumber, offset, currPartSize, r.Logger)
+		}()
 	}
-	parts := make([]obs.Part, 0, partCount)
-	for {
-		part, ok := <-partChan
-		if !ok {
-			r.Logger.Info("push worker will quit")
-			break
-		}
-		if part.PartNumber == -1 {
-			r.Logger.Error("failed to push file part, job abandoned")
-			break
-		} else {
-			parts = append(parts, part)
-			if len(parts) == partCount {
-				close(partChan)
+	startTime := time.Now()
+	var sentBytes int64
+	var pushErr error
+	for range missing {
+		result := <-resultChan
+		if result.err != nil {
+			if pushErr == nil {
+				pushErr = result.err
+				cancel()
 			}
+			continue
+		}
+		completed[result.part.PartNumber] = result.part
+		sentBytes += result.part.Size
+		AddProgress(ctx, result.part.Size)
+		r.Replica.UploadedBytes += result.part.Size
+		if elapsed := time.Since(startTime).Seconds(); elapsed > 0 {
+			r.Replica.Throughput = int64(float64(sentBytes) / elapsed)
+		}
+		if err := r.saveUploadParts(completed); err != nil {
+			r.Logger.Error(fmt.Sprintf("failed to save completed parts of upload %s, %v", uploadId, err))
 		}
 	}
+	if pushErr != nil {
+		r.Logger.Error(fmt.Sprintf("failed to push file part, job abandoned, %v", pushErr))
+		if ctx.Err() == nil {
+			r.abortUpload(context.Background(), name, uploadId)
+		}
+		return pushErr
+	}
+	if len(missing) != 0 {
+		r.Logger.Info(fmt.Sprintf("%d parts of file %s uploaded in %s, throughput %d bytes/s",
+			len(missing), name, time.Since(startTime).Round(time.Second), r.Replica.Throughput))
+	}
 
-	completeMultipartUploadInput := &obs.CompleteMultipartUploadInput{}
-	completeMultipartUploadInput.Bucket = r.Config.Bucket
-	completeMultipartUploadInput.Key = name
-	completeMultipartUploadInput.UploadId = uploadId
-	completeMultipartUploadInput.Parts = parts
-	_, err = r.OBSClient.CompleteMultipartUpload(completeMultipartUploadInput)
+	if len(completed) != partCount {
+		return errors.New(fmt.Sprintf("only %d of %d parts of %s uploaded", len(completed), partCount, name))
+	}
+	err = r.ObjectStore.CompleteMultipart(ctx, name, uploadId, sortedParts(completed))
 	if err != nil {
+		if ctx.Err() == nil {
+			r.abortUpload(context.Background(), name, uploadId)
+		}
 		return err
 	}
+	r.clearUpload()
+	if err = r.imageStore.UpdateReplicaUpload(r.Replica); err != nil {
+		r.Logger.Error(fmt.Sprintf("failed to clear upload of replica %d, %v", r.Replica.ID, err))
+	}
 	r.Logger.Info(fmt.Sprintf("push %s work finished", name))
 	return nil
 }
 
-func (r *ImagePusher) deleteObject(name string) error {
-	input := &obs.DeleteObjectInput{}
-	input.Bucket = r.Config.Bucket
-	input.Key = name
+// prepareUpload resumes the upload recorded on replica when possible, otherwise a new upload is claimed.
+// parts already uploaded with expected size are returned.
+func (r *ImagePusher) prepareUpload(ctx context.Context, name string, partSize, fileSize int64, partCount int) (string, map[int]objectstore.Part, error) {
+	completed := make(map[int]objectstore.Part)
+	if len(r.Replica.UploadID) != 0 && r.Replica.UploadKey == name {
+		parts, err := r.ObjectStore.ListParts(ctx, name, r.Replica.UploadID)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			r.Logger.Warn(fmt.Sprintf("failed to list parts of upload %s, fallback to saved parts, %v", r.Replica.UploadID, err))
+			parts, err = r.loadUploadParts()
+		}
+		if err == nil {
+			for _, p := range parts {
+				if p.PartNumber < 1 || p.PartNumber > partCount {
+					continue
+				}
+				if _, size := partRange(p.PartNumber, partSize, fileSize); p.Size == size {
+					completed[p.PartNumber] = p
+				}
+			}
+			r.Logger.Info(fmt.Sprintf("resuming upload %s for file %s, %d of %d parts already uploaded",
+				r.Replica.UploadID, name, len(completed), partCount))
+			return r.Replica.UploadID, completed, nil
+		}
+		r.Logger.Info(fmt.Sprintf("upload %s for file %s can not be resumed, %v", r.Replica.UploadID, name, err))
+	} else if len(r.Replica.UploadID) != 0 {
+		r.abortUpload(ctx, r.Replica.UploadKey, r.Replica.UploadID)
+	}
+	uploadId, err := r.ObjectStore.InitiateMultipart(ctx, name)
+	if err != nil {
+		return "", nil, err
+	}
+	r.Logger.Info(fmt.Sprintf("Claiming a new upload id %s for file %s", uploadId, name))
+	r.Replica.UploadKey = name
+	r.Replica.UploadID = uploadId
+	r.Replica.UploadParts = ""
+	if err = r.imageStore.UpdateReplicaUpload(r.Replica); err != nil {
+		r.abortUpload(ctx, name, uploadId)
+		return "", nil, err
+	}
+	return uploadId, completed, nil
+}
+
+// uploadPartWithRetry uploads single part, failed part is retried with exponential backoff.
+func (r *ImagePusher) uploadPartWithRetry(ctx context.Context, name, uploadId string, index int, localPath string, offset, size int64) (objectstore.Part, error) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		r.Logger.Info(fmt.Sprintf("starting to upload block %d.", index))
+		var part objectstore.Part
+		part, err = r.ObjectStore.UploadPart(ctx, name, uploadId, index, localPath, offset, size)
+		if err == nil {
+			r.Logger.Info(fmt.Sprintf("upload block %d finished", index))
+			return part, nil
+		}
+		if ctx.Err() != nil || attempt >= r.Config.PartMaxRetry {
+			break
+		}
+		backoff := time.Duration(r.Config.PartRetryInterval) * time.Second * time.Duration(1<<attempt)
+		r.Logger.Warn(fmt.Sprintf("upload block %d failed with error %v, will retry in %s", index, err, backoff))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return objectstore.Part{}, ctx.Err()
+		}
+	}
+	r.Logger.Error(fmt.Sprintf("upload block %d failed with error %v", index, err))
+	return objectstore.Part{}, errors.New(fmt.Sprintf("failed to upload part %d of %s, %v", index, name, err))
+}
+
+func (r *ImagePusher) saveUploadParts(completed map[int]objectstore.Part) error {
+	content, err := json.Marshal(sortedParts(completed))
+	if err != nil {
+		return err
+	}
+	r.Replica.UploadParts = string(content)
+	return r.imageStore.UpdateReplicaUpload(r.Replica)
+}
+
+func (r *ImagePusher) loadUploadParts() ([]objectstore.Part, error) {
+	var parts []objectstore.Part
+	if len(r.Replica.UploadParts) == 0 {
+		return parts, nil
+	}
+	err := json.Unmarshal([]byte(r.Replica.UploadParts), &parts)
+	return parts, err
+}
+
+// abortUpload aborts upload and forgets it on replica, failure is only logged since stale uploads are swept later.
+func (r *ImagePusher) abortUpload(ctx context.Context, name, uploadId string) {
+	if err := r.ObjectStore.AbortMultipart(ctx, name, uploadId); err != nil {
+		r.Logger.Error(fmt.Sprintf("failed to abort upload %s for file %s, %v", uploadId, name, err))
+	} else {
+		r.Logger.Info(fmt.Sprintf("upload %s for file %s aborted", uploadId, name))
+	}
+	r.clearUpload()
+	if err := r.imageStore.UpdateReplicaUpload(r.Replica); err != nil {
+		r.Logger.Error(fmt.Sprintf("failed to clear upload of replica %d, %v", r.Replica.ID, err))
+	}
+}
+
+func (r *ImagePusher) clearUpload() {
+	r.Replica.UploadKey = ""
+	r.Replica.UploadID = ""
+	r.Replica.UploadParts = ""
+}
+
+func partRange(partNumber int, partSize, fileSize int64) (int64, int64) {
+	offset := int64(partNumber-1) * partSize
+	if offset+partSize > fileSize {
+		return offset, fileSize - offset
+	}
+	return offset, partSize
+}
+
+func sortedParts(completed map[int]objectstore.Part) []objectstore.Part {
+	parts := make([]objectstore.Part, 0, len(completed))
+	for _, p := range completed {
+		parts = append(parts, p)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	return parts
+}
 
-	_, err := r.OBSClient.DeleteObject(input)
+func (r *ImagePusher) deleteObject(ctx context.Context, name string) error {
+	err := r.ObjectStore.Delete(ctx, name)
 	if err != nil {
 		r.Logger.Error(fmt.Sprintf("failed to delete object %s", name))
 		return err
@@ -0,0 +1,133 @@
+package workers
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/omnibuildplatform/omni-repository/common/config"
+	"github.com/omnibuildplatform/omni-repository/common/models"
+	"github.com/omnibuildplatform/omni-repository/common/objectstore"
+	"github.com/omnibuildplatform/omni-repository/common/storage"
+	"go.uber.org/zap"
+)
+
+const RehydrateFileSuffix = ".rehydrate"
+
+// imageLocks serializes eviction and rehydration of the same image.
+var imageLocks = struct {
+	sync.Mutex
+	locks map[int]*imageLock
+}{locks: make(map[int]*imageLock)}
+
+type imageLock struct {
+	sync.Mutex
+	refs int
+}
+
+func lockImage(id int) func() {
+	imageLocks.Lock()
+	lock, ok := imageLocks.locks[id]
+	if !ok {
+		lock = &imageLock{}
+		imageLocks.locks[id] = lock
+	}
+	lock.refs++
+	imageLocks.Unlock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		imageLocks.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(imageLocks.locks, id)
+		}
+		imageLocks.Unlock()
+	}
+}
+
+// RehydrateImage restores the evicted local copy of image from one of its pushed replicas, concurrent calls for
+// the same image wait for the first one to finish.
+func RehydrateImage(ctx context.Context, config config.ImagePusher, imageStore *storage.ImageStorage, image *models.Image, localFolder string, logger *zap.Logger) error {
+	unlock := lockImage(image.ID)
+	defer unlock()
+	latest, err := imageStore.GetImageByIDIncludingDeleted(image.ID)
+	if err != nil {
+		return err
+	}
+	if !latest.Evicted {
+		image.Evicted = false
+		return nil
+	}
+	replicas, err := imageStore.GetReplicasByImageID(image.ID)
+	if err != nil {
+		return err
+	}
+	_, _, imageKey := ImageObjectKeys(image)
+	imagePath := path.Join(localFolder, image.ImagePath)
+	for _, replica := range replicas {
+		if replica.Status != models.ReplicaPushed {
+			continue
+		}
+		target, ok := config.Targets[replica.Target]
+		if !ok {
+			continue
+		}
+		store, err := objectstore.NewObjectStore(target)
+		if err == nil {
+			err = fetchObject(ctx, store, imageKey, imagePath, image)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Error(fmt.Sprintf("failed to rehydrate image %d from target %s, %v", image.ID, replica.Target, err))
+			continue
+		}
+		now := time.Now()
+		image.Evicted = false
+		image.LastAccessTime = &now
+		if err = imageStore.UpdateImageCache(image); err != nil {
+			return err
+		}
+		logger.Info(fmt.Sprintf("image %d rehydrated from target %s", image.ID, replica.Target))
+		return nil
+	}
+	return errors.New(fmt.Sprintf("no publish target available to rehydrate image %d", image.ID))
+}
+
+// fetchObject downloads object into a temporary file aside of imagePath, file is renamed into place when checksum matched.
+func fetchObject(ctx context.Context, store objectstore.ObjectStore, key, imagePath string, image *models.Image) error {
+	reader, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	tempPath := imagePath + RehydrateFileSuffix
+	defer os.Remove(tempPath)
+	tempFile, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	hasher, err := getHasher(image.Algorithm)
+	if err != nil {
+		tempFile.Close()
+		return err
+	}
+	_, err = io.CopyBuffer(io.MultiWriter(tempFile, hasher), newThrottledReader(ctx, reader, 0), make([]byte, HashingBuffer))
+	tempFile.Close()
+	if err != nil {
+		return err
+	}
+	if checksum := hex.EncodeToString(hasher.Sum(nil)); checksum != image.Checksum {
+		return errors.New(fmt.Sprintf("checksum of fetched file mismatched, expected %s while actual %s",
+			image.Checksum, checksum))
+	}
+	return os.Rename(tempPath, imagePath)
+}
@@ -0,0 +1,60 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/omnibuildplatform/omni-repository/common/config"
+	"github.com/omnibuildplatform/omni-repository/common/messages"
+	"github.com/omnibuildplatform/omni-repository/common/models"
+	"github.com/omnibuildplatform/omni-repository/common/storage"
+	"go.uber.org/zap"
+)
+
+// ImageRemoteVerifier verifies objects of a pushed replica on its publish target on request, replica is
+// marked failed when objects differ from local files.
+type ImageRemoteVerifier struct {
+	imageStore *storage.ImageStorage
+	Image      *models.Image
+	Replica    *models.ImageReplica
+	Logger     *zap.Logger
+	Pusher     *ImagePusher
+}
+
+func NewImageRemoteVerifier(config config.ImagePusher, imageStore *storage.ImageStorage, image *models.Image, replica *models.ImageReplica, localFolder string, logger *zap.Logger, notifier messages.Notifier) (*ImageRemoteVerifier, error) {
+	pusher, err := NewImagePusher(config, imageStore, image, replica, localFolder, logger, 1, notifier)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageRemoteVerifier{
+		imageStore: imageStore,
+		Image:      image,
+		Replica:    replica,
+		Logger:     logger,
+		Pusher:     pusher,
+	}, nil
+}
+
+func (r *ImageRemoteVerifier) DoWork(ctx context.Context) error {
+	//evicted image is rehydrated first since etags are computed from local file
+	err := r.Pusher.VerifyRemote(ctx)
+	var mismatch *RemoteMismatchError
+	if err != nil && !errors.As(err, &mismatch) {
+		//request is kept and the job retried
+		r.Logger.Error(fmt.Sprintf("failed to verify objects of image %d on target %s, %v", r.Image.ID, r.Replica.Target, err))
+		return err
+	}
+	if err != nil {
+		r.Logger.Error(fmt.Sprintf("objects of image %d on target %s mismatched, %v", r.Image.ID, r.Replica.Target, err))
+		if failErr := r.Pusher.FailVerification(err); failErr != nil && !errors.Is(failErr, ErrImageWithdrawn) {
+			return failErr
+		}
+	} else {
+		r.Logger.Info(fmt.Sprintf("objects of image %d on target %s verified", r.Image.ID, r.Replica.Target))
+	}
+	return r.imageStore.ClearReplicaVerification(r.Replica)
+}
+
+func (r *ImageRemoteVerifier) Close() {
+}
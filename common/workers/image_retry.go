@@ -0,0 +1,127 @@
+package workers
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/omnibuildplatform/omni-repository/common/config"
+	"github.com/omnibuildplatform/omni-repository/common/models"
+)
+
+// failure classes of images, retry policy decides which of them are retried automatically.
+const (
+	FailureNetwork  = "network"
+	FailureSource   = "source"
+	FailurePolicy   = "policy"
+	FailureChecksum = "checksum"
+	FailureScan     = "scan"
+	FailureInternal = "internal"
+)
+
+// ClassifyFailure tells the class of error which failed work of workType.
+func ClassifyFailure(workType ImageWorkType, err error) string {
+	var policyErr *PolicyError
+	var checksumErr *ChecksumMismatchError
+	var sourceErr *SourceError
+	var netErr net.Error
+	if errors.As(err, &policyErr) {
+		return FailurePolicy
+	} else if errors.As(err, &sourceErr) {
+		return FailureSource
+	} else if errors.As(err, &checksumErr) {
+		return FailureChecksum
+	} else if errors.As(err, &netErr) {
+		return FailureNetwork
+	}
+	switch workType {
+	case PullImageWork:
+		//source rejected request or blocks failed after retries
+		return FailureNetwork
+	case ScanImageWork:
+		return FailureScan
+	}
+	return FailureInternal
+}
+
+// UnavailableRetryInterval is the delay before works waiting for an unavailable dependency, e.g. scanner,
+// are tried again.
+const UnavailableRetryInterval = time.Minute
+
+// RetryLaterError tells work couldn't be done while a dependency is unavailable, image is left where work
+// started and the job is queued again after Delay without counting an attempt.
+type RetryLaterError struct {
+	Err   error
+	Delay time.Duration
+}
+
+func (e *RetryLaterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryLaterError) Unwrap() error {
+	return e.Err
+}
+
+// AutoRetryStage tells whether failure of work is retried on image level, push failures are retried per
+// replica by image pusher.
+func AutoRetryStage(workType ImageWorkType) bool {
+	return workType == PullImageWork || workType == VerifyImageWork || workType == ScanImageWork
+}
+
+// ApplyRetryPolicy records failure of image in stage and schedules automatic retry when policy allows.
+func ApplyRetryPolicy(policy config.Retry, image *models.Image, stage ImageWorkType, err error) {
+	image.FailedStage = string(stage)
+	image.FailureClass = ClassifyFailure(stage, err)
+	image.RetryTime = nil
+	if !AutoRetryStage(stage) || image.Attempts >= policy.MaxAttempts {
+		return
+	}
+	for _, class := range policy.Classes {
+		if class == image.FailureClass {
+			image.Attempts += 1
+			retryTime := time.Now().Add(time.Duration(policy.Interval) * time.Second * time.Duration(1<<(image.Attempts-1)))
+			image.RetryTime = &retryTime
+			return
+		}
+	}
+}
+
+// InferFailedStage guesses the stage images failed before failed stage was recorded.
+func InferFailedStage(image models.Image, replicas []models.ImageReplica, pipelines *Pipelines) ImageWorkType {
+	if len(image.FailedStage) != 0 {
+		return ImageWorkType(image.FailedStage)
+	}
+	if stage, ok := pipelines.Registry.Stage(image.Stage); ok {
+		return stage.WorkType
+	}
+	for _, replica := range replicas {
+		if replica.Status == models.ReplicaFailed {
+			return PushImageWork
+		}
+	}
+	if image.DownloadFinishTime == nil && len(image.SourceUrl) != 0 {
+		return PullImageWork
+	} else if image.VerifyFinishTime == nil {
+		return VerifyImageWork
+	} else if pipelines.Has(image.ExternalComponent, StageScan) {
+		return ScanImageWork
+	}
+	return PushImageWork
+}
+
+// RestartStatus returns the status from which image works restart at stage.
+func RestartStatus(stage ImageWorkType, scanned bool) models.ImageStatus {
+	switch stage {
+	case PullImageWork:
+		return models.ImageCreated
+	case VerifyImageWork:
+		return models.ImageDownloaded
+	case ScanImageWork:
+		return models.ImageVerified
+	}
+	if scanned {
+		return models.ImageScanned
+	}
+	return models.ImageVerified
+}
@@ -0,0 +1,110 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/omnibuildplatform/omni-repository/common/config"
+	"github.com/omnibuildplatform/omni-repository/common/messages"
+	"github.com/omnibuildplatform/omni-repository/common/models"
+	"github.com/omnibuildplatform/omni-repository/common/scanner"
+	"github.com/omnibuildplatform/omni-repository/common/storage"
+	"go.uber.org/zap"
+)
+
+type ImageScanner struct {
+	ImageStore  *storage.ImageStorage
+	Image       *models.Image
+	LocalFolder string
+	Logger      *zap.Logger
+	Scanner     scanner.Scanner
+	Notifier    messages.Notifier
+}
+
+func NewImageScanner(config config.ImageScanner, imageStore *storage.ImageStorage, logger *zap.Logger, image *models.Image, localFolder string, notifier messages.Notifier) (*ImageScanner, error) {
+	contentScanner, err := scanner.NewScanner(config)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageScanner{
+		LocalFolder: localFolder,
+		Logger:      logger,
+		ImageStore:  imageStore,
+		Image:       image,
+		Scanner:     contentScanner,
+		Notifier:    notifier,
+	}, nil
+}
+
+func (r *ImageScanner) cleanup(ctx context.Context, err error) {
+	if IsInterrupted(ctx) {
+		checkpointImage(r.ImageStore, r.Image, models.ImageScanning, models.ImageVerified, "scan interrupted by shutdown, will be resumed", r.Logger)
+		return
+	}
+	if IsCancelled(ctx) {
+		markImageCancelled(r.ImageStore, r.Image, r.Logger, r.Notifier)
+		return
+	}
+	if transitErr := transitImage(r.ImageStore, r.Image, models.ImageFailed, err.Error(), models.ImageScanning); transitErr != nil {
+		r.Logger.Warn(fmt.Sprintf("image %d not marked failed, %v", r.Image.ID, transitErr))
+		return
+	}
+	r.Notifier.NonBlockPush(string(models.ImageEventFailed), r.Image.ExternalComponent, r.Image.ExternalID, map[string]interface{}{
+		"detail": err.Error(),
+	})
+}
+
+func (r *ImageScanner) DoWork(ctx context.Context) error {
+	var err error
+	err = transitImage(r.ImageStore, r.Image, models.ImageScanning, r.Image.StatusDetail,
+		models.ImageVerified, models.ImageScanning, models.ImageFailed)
+	if err != nil {
+		return err
+	}
+	imageReader, err := os.OpenFile(path.Join(r.LocalFolder, r.Image.ImagePath), os.O_RDONLY, 0644)
+	if err != nil {
+		r.cleanup(ctx, err)
+		return err
+	}
+	defer imageReader.Close()
+	result, err := r.Scanner.Scan(ctx, imageReader)
+	var unavailable *scanner.UnavailableError
+	if errors.As(err, &unavailable) && ctx.Err() == nil {
+		//outage of scanner says nothing about image, scan again once scanner is back
+		checkpointImage(r.ImageStore, r.Image, models.ImageScanning, models.ImageVerified,
+			fmt.Sprintf("scan postponed, %v", err), r.Logger)
+		return &RetryLaterError{Err: err, Delay: UnavailableRetryInterval}
+	}
+	if err != nil {
+		r.cleanup(ctx, err)
+		return err
+	}
+	if result.Infected {
+		err = transitImage(r.ImageStore, r.Image, models.ImageQuarantined,
+			fmt.Sprintf("image quarantined, signature %s found", result.Signature), models.ImageScanning)
+		if err != nil {
+			return err
+		}
+		r.Notifier.NonBlockPush(string(models.ImageEventQuarantined), r.Image.ExternalComponent, r.Image.ExternalID, map[string]interface{}{
+			"signature": result.Signature,
+		})
+		r.Logger.Warn(fmt.Sprintf("image %d quarantined, signature %s found", r.Image.ID, result.Signature))
+		return nil
+	}
+	err = transitImage(r.ImageStore, r.Image, models.ImageScanned, "no threat found", models.ImageScanning)
+	if errors.Is(err, ErrImageWithdrawn) {
+		return err
+	} else if err != nil {
+		r.cleanup(ctx, err)
+		return err
+	}
+	r.Notifier.NonBlockPush(string(models.ImageEventScanned), r.Image.ExternalComponent, r.Image.ExternalID, map[string]interface{}{})
+	r.Logger.Info(fmt.Sprintf("image %d successfully scanned", r.Image.ID))
+	return nil
+}
+
+func (r *ImageScanner) Close() {
+}
@@ -0,0 +1,265 @@
+package workers
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/omnibuildplatform/omni-repository/common/config"
+	"github.com/omnibuildplatform/omni-repository/common/messages"
+	"github.com/omnibuildplatform/omni-repository/common/models"
+	"github.com/omnibuildplatform/omni-repository/common/objectstore"
+	"github.com/omnibuildplatform/omni-repository/common/storage"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const ScrubResultOK = "ok"
+const RepairFileSuffix = ".repair"
+
+type ImageScrubber struct {
+	ImageStore  *storage.ImageStorage
+	Image       *models.Image
+	LocalFolder string
+	Logger      *zap.Logger
+	Client      http.Client
+	Config      config.Scrubber
+	// publish targets which pushed replicas are fetched from when repairing
+	PusherConfig config.ImagePusher
+	Notifier     messages.Notifier
+}
+
+func NewImageScrubber(config config.Scrubber, pusherConfig config.ImagePusher, imageStore *storage.ImageStorage, logger *zap.Logger, image *models.Image, localFolder string, notifier messages.Notifier) (*ImageScrubber, error) {
+	return &ImageScrubber{
+		//image path would be rewritten into external url when pushed, use the fixed layout instead
+		LocalFolder: path.Join(localFolder, fmt.Sprintf("%d/%s", image.UserId, image.Checksum)),
+		Logger:      logger,
+		ImageStore:  imageStore,
+		Image:       image,
+		Client: http.Client{
+			Timeout: 60 * 20 * time.Second,
+		},
+		Config:       config,
+		PusherConfig: pusherConfig,
+		Notifier:     notifier,
+	}, nil
+}
+
+func (r *ImageScrubber) DoWork(ctx context.Context) error {
+	//hold image lock so that the file is not evicted or rehydrated while hashed or repaired
+	unlock := lockImage(r.Image.ID)
+	defer unlock()
+	latest, err := r.ImageStore.GetImageByID(r.Image.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			r.Logger.Debug(fmt.Sprintf("image %d removed before scrubbing", r.Image.ID))
+			return nil
+		}
+		return err
+	}
+	if latest.Evicted {
+		r.Logger.Debug(fmt.Sprintf("image %d evicted before scrubbing", r.Image.ID))
+		return nil
+	}
+	*r.Image = latest
+	imagePath := path.Join(r.LocalFolder, r.Image.FileName)
+	checksum, err := r.hashFile(ctx, imagePath)
+	if os.IsNotExist(err) {
+		//file goes away when image is evicted by another instance meanwhile, which is not a corruption
+		current, reloadErr := r.ImageStore.GetImageByID(r.Image.ID)
+		if reloadErr != nil {
+			return reloadErr
+		}
+		if current.Evicted {
+			r.Logger.Debug(fmt.Sprintf("image %d evicted while scrubbing", r.Image.ID))
+			return nil
+		}
+		err = errors.New(fmt.Sprintf("local copy of image is missing, %v", err))
+	} else if err == nil && checksum != r.Image.Checksum {
+		err = errors.New(fmt.Sprintf("checksum of stored file changed, expected %s while actual %s",
+			r.Image.Checksum, checksum))
+	}
+	now := time.Now()
+	r.Image.LastScrubTime = &now
+	r.Image.LastScrubResult = ScrubResultOK
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		r.Image.LastScrubResult = err.Error()
+	}
+	if updateErr := r.ImageStore.UpdateImageScrubResult(r.Image); updateErr != nil {
+		return updateErr
+	}
+	if err == nil {
+		r.Logger.Debug(fmt.Sprintf("image %d successfully scrubbed", r.Image.ID))
+		return nil
+	}
+	r.Logger.Error(fmt.Sprintf("image %d is corrupted, %v", r.Image.ID, err))
+	previousStatus := r.Image.Status
+	if err := transitImage(r.ImageStore, r.Image, models.ImageCorrupted, err.Error(), previousStatus); err != nil {
+		return err
+	}
+	r.Notifier.NonBlockPush(string(models.ImageEventCorrupted), r.Image.ExternalComponent, r.Image.ExternalID, map[string]interface{}{
+		"detail": err.Error(),
+	})
+
+	//try to restore file from a good copy, the source url first and then the pushed replicas
+	if len(r.Image.SourceUrl) != 0 {
+		repairErr := r.repair(ctx, r.Image.SourceUrl, imagePath)
+		if repairErr == nil {
+			return r.repaired(previousStatus, r.Image.SourceUrl)
+		}
+		r.Logger.Error(fmt.Sprintf("failed to repair image %d from %s, %v", r.Image.ID, r.Image.SourceUrl, repairErr))
+	}
+	replicas, replicaErr := r.ImageStore.GetReplicasByImageID(r.Image.ID)
+	if replicaErr != nil {
+		r.Logger.Error(fmt.Sprintf("failed to get replicas of image %d, %v", r.Image.ID, replicaErr))
+	}
+	_, _, imageKey := ImageObjectKeys(r.Image)
+	for _, replica := range replicas {
+		if replica.Status != models.ReplicaPushed {
+			continue
+		}
+		target, ok := r.PusherConfig.Targets[replica.Target]
+		if !ok {
+			continue
+		}
+		store, repairErr := objectstore.NewObjectStore(target)
+		if repairErr == nil {
+			repairErr = fetchObject(ctx, store, imageKey, imagePath, r.Image)
+		}
+		if repairErr != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			r.Logger.Error(fmt.Sprintf("failed to repair image %d from target %s, %v", r.Image.ID, replica.Target, repairErr))
+			continue
+		}
+		return r.repaired(previousStatus, fmt.Sprintf("target %s", replica.Target))
+	}
+	return err
+}
+
+// repaired restores status of image once its file was replaced by a good copy from source.
+func (r *ImageScrubber) repaired(previousStatus models.ImageStatus, source string) error {
+	err := transitImage(r.ImageStore, r.Image, previousStatus, fmt.Sprintf("image repaired from %s", source), models.ImageCorrupted)
+	if err != nil {
+		return err
+	}
+	//repaired file is verified against checksum of image, which makes it as good as a passed scrub
+	repairTime := time.Now()
+	r.Image.LastScrubTime = &repairTime
+	r.Image.LastScrubResult = ScrubResultOK
+	if err = r.ImageStore.UpdateImageScrubResult(r.Image); err != nil {
+		r.Logger.Error(fmt.Sprintf("failed to reset scrub result of repaired image %d, %v", r.Image.ID, err))
+	}
+	r.Notifier.NonBlockPush(string(models.ImageEventRepaired), r.Image.ExternalComponent, r.Image.ExternalID, map[string]interface{}{
+		"source": source,
+	})
+	r.Logger.Info(fmt.Sprintf("image %d successfully repaired from %s", r.Image.ID, source))
+	return nil
+}
+
+func (r *ImageScrubber) hashFile(ctx context.Context, imagePath string) (string, error) {
+	imageReader, err := os.OpenFile(imagePath, os.O_RDONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer imageReader.Close()
+	hasher, err := getHasher(r.Image.Algorithm)
+	if err != nil {
+		return "", err
+	}
+	copyBuf := make([]byte, HashingBuffer)
+	if _, err := io.CopyBuffer(hasher, newThrottledReader(ctx, imageReader, r.Config.BytesPerSecond), copyBuf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func (r *ImageScrubber) repair(ctx context.Context, source, imagePath string) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", source, nil)
+	if err != nil {
+		return err
+	}
+	// curl the pop star, we have to
+	request.Header.Set("User-Agent", "curl")
+	result, err := r.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer result.Body.Close()
+	if result.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("unacceptable status code %d when fetching %s", result.StatusCode, source))
+	}
+	repairPath := imagePath + RepairFileSuffix
+	defer os.Remove(repairPath)
+	repairFile, err := os.Create(repairPath)
+	if err != nil {
+		return err
+	}
+	hasher, err := getHasher(r.Image.Algorithm)
+	if err != nil {
+		repairFile.Close()
+		return err
+	}
+	_, err = io.Copy(io.MultiWriter(repairFile, hasher), newThrottledReader(ctx, result.Body, r.Config.BytesPerSecond))
+	repairFile.Close()
+	if err != nil {
+		return err
+	}
+	if checksum := hex.EncodeToString(hasher.Sum(nil)); checksum != r.Image.Checksum {
+		return errors.New(fmt.Sprintf("checksum of fetched file mismatched, expected %s while actual %s",
+			r.Image.Checksum, checksum))
+	}
+	return os.Rename(repairPath, imagePath)
+}
+
+func (r *ImageScrubber) Close() {
+}
+
+// throttledReader limits the read speed to keep the scrubbing within I/O budget, 0 means unlimited.
+type throttledReader struct {
+	ctx            context.Context
+	reader         io.Reader
+	bytesPerSecond int64
+	startTime      time.Time
+	total          int64
+}
+
+func newThrottledReader(ctx context.Context, reader io.Reader, bytesPerSecond int64) io.Reader {
+	return &throttledReader{
+		ctx:            ctx,
+		reader:         reader,
+		bytesPerSecond: bytesPerSecond,
+		startTime:      time.Now(),
+	}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := t.reader.Read(p)
+	AddProgress(t.ctx, int64(n))
+	if t.bytesPerSecond <= 0 {
+		return n, err
+	}
+	t.total += int64(n)
+	expected := time.Duration(float64(t.total) / float64(t.bytesPerSecond) * float64(time.Second))
+	if wait := expected - time.Since(t.startTime); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-t.ctx.Done():
+			return n, t.ctx.Err()
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,142 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/omnibuildplatform/omni-repository/common/config"
+	"github.com/omnibuildplatform/omni-repository/common/messages"
+	"github.com/omnibuildplatform/omni-repository/common/models"
+	"github.com/omnibuildplatform/omni-repository/common/objectstore"
+	"github.com/omnibuildplatform/omni-repository/common/storage"
+	"go.uber.org/zap"
+)
+
+// MaxCleanupBackoff caps the interval between retries of remote cleanup, remote cleanup is never given up
+// since the objects would be public forever.
+const MaxCleanupBackoff = 6 * time.Hour
+
+type ImageUnpublisher struct {
+	imageStore  *storage.ImageStorage
+	Image       *models.Image
+	Replica     *models.ImageReplica
+	LocalFolder string
+	Logger      *zap.Logger
+	Config      config.ImagePusher
+	ObjectStore objectstore.ObjectStore
+	Notifier    messages.Notifier
+}
+
+func NewImageUnpublisher(config config.ImagePusher, imageStore *storage.ImageStorage, image *models.Image, replica *models.ImageReplica, localFolder string, logger *zap.Logger, notifier messages.Notifier) (*ImageUnpublisher, error) {
+	target, ok := config.Targets[replica.Target]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("publish target %s not configured", replica.Target))
+	}
+	store, err := objectstore.NewObjectStore(target)
+	if err != nil {
+		logger.Error(fmt.Sprintf("failed to initialize object store for target %s, %v", replica.Target, err))
+		return nil, err
+	}
+	return &ImageUnpublisher{
+		Config:      config,
+		imageStore:  imageStore,
+		Image:       image,
+		Replica:     replica,
+		LocalFolder: localFolder,
+		Logger:      logger,
+		ObjectStore: store,
+		Notifier:    notifier,
+	}, nil
+}
+
+// cleanup keeps replica in remote cleanup pending and schedules another try with exponential backoff.
+func (r *ImageUnpublisher) cleanup(err error) {
+	r.Replica.StatusDetail = fmt.Sprintf("remote cleanup failed, %v", err)
+	r.Replica.Attempts += 1
+	backoff := time.Duration(r.Config.RetryInterval) * time.Second
+	for i := 1; i < r.Replica.Attempts && backoff < MaxCleanupBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > MaxCleanupBackoff {
+		backoff = MaxCleanupBackoff
+	}
+	retryTime := time.Now().Add(backoff)
+	r.Replica.RetryTime = &retryTime
+	r.Logger.Info(fmt.Sprintf("remote cleanup of image %d on target %s will have another try at %s",
+		r.Image.ID, r.Replica.Target, retryTime.Format(time.RFC3339)))
+	_ = r.imageStore.UpdateReplica(r.Replica)
+}
+
+func (r *ImageUnpublisher) DoWork(ctx context.Context) error {
+	if r.Image.Evicted && !r.Image.Deleted {
+		//local copy must be restored before the remote copy is gone
+		if err := RehydrateImage(ctx, r.Config, r.imageStore, r.Image, r.LocalFolder, r.Logger); err != nil {
+			r.cleanup(err)
+			return err
+		}
+	}
+	folderKey, checksumName, imageKey := ImageObjectKeys(r.Image)
+	if len(r.Replica.UploadID) != 0 {
+		if err := r.ObjectStore.AbortMultipart(ctx, r.Replica.UploadKey, r.Replica.UploadID); err != nil {
+			r.cleanup(err)
+			return err
+		}
+		r.Replica.UploadKey = ""
+		r.Replica.UploadID = ""
+		r.Replica.UploadParts = ""
+		if err := r.imageStore.UpdateReplicaUpload(r.Replica); err != nil {
+			return err
+		}
+	}
+	//folder marker goes last, it's shared by the objects
+	for _, key := range []string{imageKey, checksumName, folderKey} {
+		if err := r.ObjectStore.Delete(ctx, key); err != nil {
+			r.Logger.Error(fmt.Sprintf("failed to delete object %s on target %s, %v", key, r.Replica.Target, err))
+			r.cleanup(err)
+			return err
+		}
+	}
+	r.Replica.Status = models.ReplicaUnpublished
+	r.Replica.StatusDetail = "objects removed from target"
+	r.Replica.ImageUrl = ""
+	r.Replica.ChecksumUrl = ""
+	r.Replica.Attempts = 0
+	r.Replica.RetryTime = nil
+	if err := r.imageStore.UpdateReplica(r.Replica); err != nil {
+		return err
+	}
+	r.Logger.Info(fmt.Sprintf("image %d unpublished from target %s", r.Image.ID, r.Replica.Target))
+	if r.Image.Deleted {
+		//image record is removed by cleaner once all replicas are cleaned up
+		return nil
+	}
+	r.Notifier.NonBlockPush(string(models.ImageEventUnpublished), r.Image.ExternalComponent, r.Image.ExternalID, map[string]interface{}{
+		"target": r.Replica.Target,
+	})
+	return r.refreshImageStatus()
+}
+
+// refreshImageStatus marks image unpublished when all of its replicas are unpublished, images moved out of
+// publish meanwhile, e.g. cancelled or corrupted, are kept as they are.
+func (r *ImageUnpublisher) refreshImageStatus() error {
+	replicas, err := r.imageStore.GetReplicasByImageID(r.Image.ID)
+	if err != nil {
+		return err
+	}
+	for _, replica := range replicas {
+		if replica.Status != models.ReplicaUnpublished {
+			return nil
+		}
+	}
+	err = transitImage(r.imageStore, r.Image, models.ImageUnpublished, "image removed from all publish targets", pushableStatuses...)
+	if errors.Is(err, ErrImageWithdrawn) {
+		r.Logger.Info(fmt.Sprintf("image %d left in status %s after unpublish", r.Image.ID, r.Image.Status))
+		return nil
+	}
+	return err
+}
+
+func (r *ImageUnpublisher) Close() {
+}
@@ -12,7 +12,10 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
+	"github.com/omnibuildplatform/omni-repository/common/config"
+	"github.com/omnibuildplatform/omni-repository/common/filetype"
 	"github.com/omnibuildplatform/omni-repository/common/messages"
 
 	"github.com/omnibuildplatform/omni-repository/common/models"
@@ -22,36 +25,56 @@ import (
 
 const HashingBuffer = 1024 * 1024 * 10
 
+// ChecksumMismatchError tells image file differs from the checksum provided.
+type ChecksumMismatchError struct {
+	Detail string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return e.Detail
+}
+
 type ImageVerifier struct {
 	ImageStore  *storage.ImageStorage
 	Image       *models.Image
 	LocalFolder string
 	Logger      *zap.Logger
 	Worker      int
+	Policy      config.FilePolicy
 	Notifier    messages.Notifier
 }
 
-func NewImageVerifier(imageStore *storage.ImageStorage, logger *zap.Logger, image *models.Image, localFolder string, worker int, notifier messages.Notifier) (*ImageVerifier, error) {
+func NewImageVerifier(imageStore *storage.ImageStorage, logger *zap.Logger, image *models.Image, localFolder string, worker int, policy config.FilePolicy, notifier messages.Notifier) (*ImageVerifier, error) {
 	return &ImageVerifier{
 		LocalFolder: localFolder,
 		Logger:      logger,
 		ImageStore:  imageStore,
 		Image:       image,
 		Worker:      worker,
+		Policy:      policy,
 		Notifier:    notifier,
 	}, nil
 }
 
-func (r *ImageVerifier) cleanup(err error) {
-	r.Image.Status = models.ImageFailed
-	r.Image.StatusDetail = err.Error()
-	_ = r.ImageStore.UpdateImageStatusAndDetail(r.Image)
+func (r *ImageVerifier) cleanup(ctx context.Context, err error) {
+	if IsInterrupted(ctx) {
+		checkpointImage(r.ImageStore, r.Image, models.ImageVerifying, models.ImageDownloaded, "verification interrupted by shutdown, will be resumed", r.Logger)
+		return
+	}
+	if IsCancelled(ctx) {
+		markImageCancelled(r.ImageStore, r.Image, r.Logger, r.Notifier)
+		return
+	}
+	if transitErr := transitImage(r.ImageStore, r.Image, models.ImageFailed, err.Error(), models.ImageVerifying); transitErr != nil {
+		r.Logger.Warn(fmt.Sprintf("image %d not marked failed, %v", r.Image.ID, transitErr))
+		return
+	}
 	r.Notifier.NonBlockPush(string(models.ImageEventFailed), r.Image.ExternalComponent, r.Image.ExternalID, map[string]interface{}{
 		"detail": err.Error(),
 	})
 }
 
-func (r *ImageVerifier) getHasher(algorithm string) (hash.Hash, error) {
+func getHasher(algorithm string) (hash.Hash, error) {
 	if strings.ToLower(algorithm) == "md5" {
 		return md5.New(), nil
 	} else if strings.ToLower(algorithm) == "sha256" {
@@ -62,49 +85,84 @@ func (r *ImageVerifier) getHasher(algorithm string) (hash.Hash, error) {
 
 func (r *ImageVerifier) DoWork(ctx context.Context) error {
 	var err error
-	r.Image.Status = models.ImageVerifying
-	err = r.ImageStore.UpdateImageStatus(r.Image)
+	err = transitImage(r.ImageStore, r.Image, models.ImageVerifying, r.Image.StatusDetail,
+		models.ImageDownloaded, models.ImageVerifying, models.ImageFailed)
+	if err != nil {
+		return err
+	}
+	startTime := time.Now()
+	r.Image.VerifyStartTime = &startTime
+	r.Image.VerifyFinishTime = nil
+	err = r.ImageStore.UpdateImageStageTime(r.Image)
 	if err != nil {
 		return err
 	}
 	imagePath := path.Join(r.LocalFolder, r.Image.ImagePath)
-	if _, err := os.Stat(imagePath); err != nil {
-		r.cleanup(err)
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		r.cleanup(ctx, err)
+		return err
+	}
+	if err = checkPolicySize(r.Policy, r.Image.ExternalComponent, info.Size()); err != nil {
+		r.cleanup(ctx, err)
+		return err
+	}
+	r.Image.Size = info.Size()
+	r.Image.MediaType, err = filetype.DetectFile(imagePath)
+	if err != nil {
+		r.cleanup(ctx, err)
+		return err
+	}
+	err = r.ImageStore.UpdateImageFileInfo(r.Image)
+	if err != nil {
+		r.cleanup(ctx, err)
+		return err
+	}
+	if err = checkPolicyType(r.Policy, r.Image.ExternalComponent, r.Image.MediaType); err != nil {
+		r.cleanup(ctx, err)
 		return err
 	}
 	imageReader, err := os.OpenFile(imagePath, os.O_RDONLY, 0644)
 	if err != nil {
-		r.cleanup(err)
+		r.cleanup(ctx, err)
 		return err
 	}
 	defer imageReader.Close()
-	hasher, err := r.getHasher(r.Image.Algorithm)
+	hasher, err := getHasher(r.Image.Algorithm)
 	if err != nil {
-		r.cleanup(err)
+		r.cleanup(ctx, err)
 		return err
 	}
 	copyBuf := make([]byte, HashingBuffer)
-	if _, err := io.CopyBuffer(hasher, imageReader, copyBuf); err != nil {
-		r.cleanup(err)
+	//hashing large image takes a while, stop it when image is cancelled
+	if _, err := io.CopyBuffer(hasher, newThrottledReader(ctx, imageReader, 0), copyBuf); err != nil {
+		r.cleanup(ctx, err)
 		return err
 	}
 	checksum := hex.EncodeToString(hasher.Sum(nil))
 	if checksum != r.Image.Checksum {
-		err = errors.New(fmt.Sprintf("checksum is not identical to image file's provided %s while actual %s ",
-			r.Image.Checksum, checksum))
-		r.cleanup(err)
+		err = &ChecksumMismatchError{Detail: fmt.Sprintf("checksum is not identical to image file's provided %s while actual %s ",
+			r.Image.Checksum, checksum)}
+		r.cleanup(ctx, err)
 		return err
 	}
 	err = r.generateChecksumFile(checksum)
 	if err != nil {
-		r.cleanup(err)
+		r.cleanup(ctx, err)
 		return err
 	}
-	r.Image.Status = models.ImageVerified
-	r.Image.StatusDetail = "checksum are verified"
-	err = r.ImageStore.UpdateImageStatusAndDetail(r.Image)
+	finishTime := time.Now()
+	r.Image.VerifyFinishTime = &finishTime
+	err = r.ImageStore.UpdateImageStageTime(r.Image)
 	if err != nil {
-		r.cleanup(err)
+		r.cleanup(ctx, err)
+		return err
+	}
+	err = transitImage(r.ImageStore, r.Image, models.ImageVerified, "checksum are verified", models.ImageVerifying)
+	if errors.Is(err, ErrImageWithdrawn) {
+		return err
+	} else if err != nil {
+		r.cleanup(ctx, err)
 		return err
 	}
 	r.Notifier.NonBlockPush(string(models.ImageEventVerified), r.Image.ExternalComponent, r.Image.ExternalID, map[string]interface{}{
@@ -0,0 +1,58 @@
+package workers
+
+import (
+	"fmt"
+	"sync"
+)
+
+// InFlightWorks tracks works which are queued or running, so that the same work of an image is never
+// performed concurrently.
+type InFlightWorks struct {
+	lock   sync.Mutex
+	works  map[string]struct{}
+	images map[int]int
+}
+
+func NewInFlightWorks() *InFlightWorks {
+	return &InFlightWorks{
+		works:  make(map[string]struct{}),
+		images: make(map[int]int),
+	}
+}
+
+// key identifies work by image and work type, replica is included so that an image can be pushed to
+// or removed from different targets in parallel.
+func (i *InFlightWorks) key(work ImageWork) string {
+	return fmt.Sprintf("%d/%s/%d", work.Image.ID, work.Type, work.Replica.ID)
+}
+
+// Acquire returns false when the same work is already in flight, clean work is refused as well while
+// any other work of the image is in flight since it removes the files they use.
+func (i *InFlightWorks) Acquire(work ImageWork) bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	key := i.key(work)
+	if _, ok := i.works[key]; ok {
+		return false
+	}
+	if work.Type == CleanImageWork && i.images[work.Image.ID] != 0 {
+		return false
+	}
+	i.works[key] = struct{}{}
+	i.images[work.Image.ID] += 1
+	return true
+}
+
+func (i *InFlightWorks) Release(work ImageWork) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	key := i.key(work)
+	if _, ok := i.works[key]; !ok {
+		return
+	}
+	delete(i.works, key)
+	i.images[work.Image.ID] -= 1
+	if i.images[work.Image.ID] == 0 {
+		delete(i.images, work.Image.ID)
+	}
+}
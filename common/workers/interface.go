@@ -19,13 +19,24 @@ type (
 type ImageWorkType string
 
 const (
-	PullImageWork  ImageWorkType = "PullImageWork"
-	SignImageWork  ImageWorkType = "SignImageWork"
-	PushImageWork  ImageWorkType = "PushImageWork"
-	CleanImageWork ImageWorkType = "CleanImageWork"
+	PullImageWork   ImageWorkType = "PullImageWork"
+	VerifyImageWork ImageWorkType = "VerifyImageWork"
+	ScanImageWork   ImageWorkType = "ScanImageWork"
+	PushImageWork   ImageWorkType = "PushImageWork"
+	CleanImageWork  ImageWorkType = "CleanImageWork"
+	// former name of VerifyImageWork, jobs queued under it are renamed on start
+	LegacySignImageWork ImageWorkType = "SignImageWork"
+	// remove objects of replica from publish target
+	UnpublishImageWork ImageWorkType = "UnpublishImageWork"
+	// compare objects of pushed replica on publish target with local files
+	VerifyRemoteImageWork ImageWorkType = "VerifyRemoteImageWork"
 )
 
 type ImageWork struct {
 	Image models.Image
-	Type  ImageWorkType
+	// replica to push, unpublish or verify, only used by replica works
+	Replica models.ImageReplica
+	Type    ImageWorkType
+	// persistent job the work is claimed from
+	Job models.Job
 }
@@ -0,0 +1,140 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/omnibuildplatform/omni-repository/common/models"
+	"github.com/omnibuildplatform/omni-repository/common/storage"
+	"gorm.io/gorm"
+)
+
+// CleanRecheckInterval is the delay before clean work of deleted image is retried while its
+// published objects are still being removed.
+const CleanRecheckInterval = time.Minute
+
+// JobRetryInterval and MaxJobAttempts limit jobs which failed without moving image forward.
+const JobRetryInterval = 30 * time.Second
+const MaxJobAttempts = 5
+
+func jobKey(jobType string, replicaID int) string {
+	return fmt.Sprintf("%s/%d", jobType, replicaID)
+}
+
+// PlanJobs derives the works required to move image forward from its pipeline stage and status of image
+// and its replicas.
+func PlanJobs(image models.Image, replicas []models.ImageReplica, pipelines *Pipelines) []models.Job {
+	var jobs []models.Job
+	now := time.Now()
+	cleanupPending := false
+	for _, replica := range replicas {
+		if replica.Status == models.ReplicaRemoteCleanupPending {
+			cleanupPending = true
+			jobs = append(jobs, storage.NewJob(string(UnpublishImageWork), image, replica.ID, retryTime(replica.RetryTime, now)))
+		} else if replica.Status == models.ReplicaPushing {
+			//replica being pushed is marked for remote cleanup once the push stops
+			cleanupPending = true
+		}
+	}
+	if image.Deleted {
+		runAfter := now
+		if cleanupPending {
+			runAfter = now.Add(CleanRecheckInterval)
+		}
+		return append(jobs, storage.NewJob(string(CleanImageWork), image, 0, runAfter))
+	}
+	//local file of corrupted image can't tell whether objects on target are intact
+	if image.Status != models.ImageCorrupted {
+		for _, replica := range replicas {
+			if replica.Status == models.ReplicaPushed && replica.VerifyRequestTime != nil {
+				jobs = append(jobs, storage.NewJob(string(VerifyRemoteImageWork), image, replica.ID, now))
+			}
+		}
+	}
+	switch image.Status {
+	case models.ImageFailed:
+		//failed stage is retried automatically when retry policy allowed
+		stage := ImageWorkType(image.FailedStage)
+		if image.RetryTime != nil && AutoRetryStage(stage) && (stage != PullImageWork || len(image.SourceUrl) != 0) {
+			jobs = append(jobs, storage.NewJob(string(stage), image, 0, retryTime(image.RetryTime, now)))
+		}
+	case models.ImageQuarantined, models.ImageCorrupted, models.ImageUnpublished, models.ImageCancelled:
+		//images are held until they are released, repaired or published again
+	default:
+		stage, ok := pipelines.Registry.Stage(pipelines.Current(image))
+		if ok && !stage.Completed(image, replicas) {
+			jobs = append(jobs, stage.Plan(image, replicas, now)...)
+		}
+	}
+	return jobs
+}
+
+func planPushJobs(image models.Image, replicas []models.ImageReplica, now time.Time) []models.Job {
+	var jobs []models.Job
+	for _, replica := range replicas {
+		if replica.Status == models.ReplicaPending || replica.Status == models.ReplicaPushing {
+			jobs = append(jobs, storage.NewJob(string(PushImageWork), image, replica.ID, now))
+		} else if replica.Status == models.ReplicaFailed && replica.RetryTime != nil {
+			jobs = append(jobs, storage.NewJob(string(PushImageWork), image, replica.ID, retryTime(replica.RetryTime, now)))
+		}
+	}
+	return jobs
+}
+
+func retryTime(retry *time.Time, now time.Time) time.Time {
+	if retry != nil && retry.After(now) {
+		return *retry
+	}
+	return now
+}
+
+// ScheduleImageJobs enqueues the works image currently requires, finished is the job which just
+// completed on image if any, it's retried with backoff when it failed without moving image forward.
+func ScheduleImageJobs(imageStore *storage.ImageStorage, imageID int, pipelines *Pipelines, finished *models.Job) error {
+	image, err := imageStore.GetImageByIDIncludingDeleted(imageID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			//image has been cleaned
+			return nil
+		}
+		return err
+	}
+	replicas, err := imageStore.GetReplicasByImageID(imageID)
+	if err != nil {
+		return err
+	}
+	if !image.Deleted && pipelines.Advance(&image, replicas) {
+		if err = imageStore.UpdateImagePipelineStage(&image); err != nil {
+			return err
+		}
+	}
+	jobs := PlanJobs(image, replicas, pipelines)
+	if finished != nil && finished.State == models.JobFailed {
+		var planned []models.Job
+		for _, job := range jobs {
+			if jobKey(job.Type, job.ReplicaID) == jobKey(finished.Type, finished.ReplicaID) && !job.RunAfter.After(time.Now()) {
+				if finished.Attempts >= MaxJobAttempts {
+					continue
+				}
+				job.Attempts = finished.Attempts
+				job.RunAfter = time.Now().Add(JobRetryInterval * time.Duration(finished.Attempts))
+			}
+			planned = append(planned, job)
+		}
+		jobs = planned
+	}
+	return imageStore.EnqueueJobs(jobs)
+}
+
+// JobRequired reports whether job is still part of the works image requires, jobs become obsolete when
+// image changed after they were queued.
+func JobRequired(job models.Job, image models.Image, replicas []models.ImageReplica, pipelines *Pipelines) bool {
+	pipelines.Advance(&image, replicas)
+	for _, planned := range PlanJobs(image, replicas, pipelines) {
+		if jobKey(planned.Type, planned.ReplicaID) == jobKey(job.Type, job.ReplicaID) {
+			return true
+		}
+	}
+	return false
+}
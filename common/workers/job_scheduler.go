@@ -0,0 +1,130 @@
+package workers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/omnibuildplatform/omni-repository/common/config"
+	"github.com/omnibuildplatform/omni-repository/common/models"
+)
+
+const (
+	MinPriority = 0
+	MaxPriority = 9
+)
+
+// JobScheduler picks jobs to claim. Jobs of the highest effective priority go first, a waiting job gains
+// one priority level every aging interval. Among jobs of the same priority, components share workers by
+// the weight of their policy and users of a component share workers equally, jobs of the same user are
+// run in order of queuing.
+type JobScheduler struct {
+	Policies      map[string]config.FilePolicy
+	AgingInterval time.Duration
+}
+
+func NewJobScheduler(config config.Scheduler, policies map[string]config.FilePolicy) *JobScheduler {
+	return &JobScheduler{
+		Policies:      policies,
+		AgingInterval: time.Duration(config.AgingInterval) * time.Second,
+	}
+}
+
+// ClampPriority limits priority into the supported range.
+func ClampPriority(priority int) int {
+	if priority < MinPriority {
+		return MinPriority
+	}
+	if priority > MaxPriority {
+		return MaxPriority
+	}
+	return priority
+}
+
+func (s *JobScheduler) aging(job models.Job, now time.Time) int {
+	if s.AgingInterval <= 0 || !now.After(job.RunAfter) {
+		return 0
+	}
+	return int(now.Sub(job.RunAfter) / s.AgingInterval)
+}
+
+func (s *JobScheduler) weight(component string) int {
+	weight := GetComponentPolicy(s.Policies, component).Weight
+	if weight <= 0 {
+		return 1
+	}
+	return weight
+}
+
+func userFlow(job models.Job) string {
+	return fmt.Sprintf("%s/%d", job.Component, job.UserID)
+}
+
+func earlier(left, right models.Job) bool {
+	if left.RunAfter.Equal(right.RunAfter) {
+		return left.ID < right.ID
+	}
+	return left.RunAfter.Before(right.RunAfter)
+}
+
+// Pick returns at most limit jobs from candidates, running jobs count against the share of their
+// components and users. The scheduling decision is recorded on picked jobs.
+func (s *JobScheduler) Pick(candidates, running []models.Job, limit int) []models.Job {
+	now := time.Now()
+	componentLoad := make(map[string]int)
+	userLoad := make(map[string]int)
+	for _, job := range running {
+		componentLoad[job.Component] += 1
+		userLoad[userFlow(job)] += 1
+	}
+	effective := make([]int, len(candidates))
+	for index, job := range candidates {
+		effective[index] = ClampPriority(job.Priority + s.aging(job, now))
+	}
+	picked := make([]models.Job, 0, limit)
+	taken := make([]bool, len(candidates))
+	for len(picked) < limit {
+		best := -1
+		for index, job := range candidates {
+			if taken[index] {
+				continue
+			}
+			if best < 0 || s.before(job, effective[index], candidates[best], effective[best], componentLoad, userLoad) {
+				best = index
+			}
+		}
+		if best < 0 {
+			break
+		}
+		job := candidates[best]
+		taken[best] = true
+		job.EffectivePriority = effective[best]
+		job.ScheduleNote = fmt.Sprintf("priority %d (base %d, aged %d), component %s running %d with weight %d, user %d running %d",
+			effective[best], job.Priority, effective[best]-job.Priority, job.Component, componentLoad[job.Component],
+			s.weight(job.Component), job.UserID, userLoad[userFlow(job)])
+		componentLoad[job.Component] += 1
+		userLoad[userFlow(job)] += 1
+		picked = append(picked, job)
+	}
+	return picked
+}
+
+// before tells whether left should be scheduled ahead of right.
+func (s *JobScheduler) before(left models.Job, leftPriority int, right models.Job, rightPriority int,
+	componentLoad, userLoad map[string]int) bool {
+	if leftPriority != rightPriority {
+		return leftPriority > rightPriority
+	}
+	if left.Component != right.Component {
+		//compare load per weight without division, loads/weights are small integers
+		leftShare := componentLoad[left.Component] * s.weight(right.Component)
+		rightShare := componentLoad[right.Component] * s.weight(left.Component)
+		if leftShare != rightShare {
+			return leftShare < rightShare
+		}
+	} else if left.UserID != right.UserID {
+		if userLoad[userFlow(left)] != userLoad[userFlow(right)] {
+			return userLoad[userFlow(left)] < userLoad[userFlow(right)]
+		}
+	}
+	return earlier(left, right)
+}
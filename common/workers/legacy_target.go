@@ -0,0 +1,30 @@
+package workers
+
+import (
+	"github.com/omnibuildplatform/omni-repository/common/config"
+	"github.com/omnibuildplatform/omni-repository/common/objectstore"
+)
+
+// LegacyTargetName is the name of the target mapped from deprecated bucket keys of image pusher.
+const LegacyTargetName = "obs"
+
+// ApplyLegacyTarget maps the deprecated endpoint, ak, sk and bucket keys of image pusher to an obs target,
+// which is the default target as well, when no target is configured. It returns whether the keys are mapped.
+func ApplyLegacyTarget(pusher *config.ImagePusher) bool {
+	if len(pusher.Targets) != 0 || len(pusher.Endpoint) == 0 {
+		return false
+	}
+	pusher.Targets = map[string]config.PublishTarget{
+		LegacyTargetName: {
+			Type:     objectstore.OBSStoreType,
+			Endpoint: pusher.Endpoint,
+			AK:       pusher.AK,
+			SK:       pusher.SK,
+			Bucket:   pusher.Bucket,
+		},
+	}
+	if len(pusher.DefaultTargets) == 0 {
+		pusher.DefaultTargets = []string{LegacyTargetName}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/omnibuildplatform/omni-repository/common/models"
+)
+
+// DefaultPipeline is used when no pipeline is configured.
+var DefaultPipeline = []string{StagePull, StageVerify, StageScan, StagePush}
+
+// Pipelines holds the stages images of each external component go through, components without their own
+// pipeline use the default one.
+type Pipelines struct {
+	Registry  *StageRegistry
+	pipelines map[string][]string
+}
+
+// NewPipelines validates configured pipelines against registry, scan stage is left out when content
+// scanning is disabled. Stages have to follow the registered order and come after the stages they require.
+func NewPipelines(registry *StageRegistry, configured map[string][]string, scanEnabled bool) (*Pipelines, error) {
+	pipelines := &Pipelines{
+		Registry:  registry,
+		pipelines: make(map[string][]string),
+	}
+	if _, ok := configured[DefaultPolicyName]; !ok {
+		pipelines.pipelines[DefaultPolicyName] = filterScan(DefaultPipeline, scanEnabled)
+	}
+	for component, stages := range configured {
+		//stages are completed by the status their workers leave image in, so they keep the registered order
+		last := -1
+		for _, name := range stages {
+			index := registry.index(name)
+			if index < 0 {
+				return nil, errors.New(fmt.Sprintf("unknown stage %s in pipeline of %s", name, component))
+			}
+			if index <= last {
+				return nil, errors.New(fmt.Sprintf("stage %s is duplicated or out of order in pipeline of %s, stages must follow the order %s",
+					name, component, strings.Join(registry.order, ", ")))
+			}
+			last = index
+			stage, _ := registry.Stage(name)
+			for _, required := range stage.Requires {
+				if !contains(stages, required) {
+					return nil, errors.New(fmt.Sprintf("stage %s requires stage %s in pipeline of %s", name, required, component))
+				}
+			}
+		}
+		pipelines.pipelines[component] = filterScan(stages, scanEnabled)
+	}
+	return pipelines, nil
+}
+
+func filterScan(stages []string, scanEnabled bool) []string {
+	filtered := make([]string, 0, len(stages))
+	for _, name := range stages {
+		if name == StageScan && !scanEnabled {
+			continue
+		}
+		filtered = append(filtered, name)
+	}
+	return filtered
+}
+
+// For returns pipeline of component.
+func (p *Pipelines) For(component string) []string {
+	if stages, ok := p.pipelines[component]; ok {
+		return stages
+	}
+	return p.pipelines[DefaultPolicyName]
+}
+
+func (p *Pipelines) Has(component, stage string) bool {
+	return contains(p.For(component), stage)
+}
+
+func contains(stages []string, stage string) bool {
+	for _, name := range stages {
+		if name == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// Entry returns the first stage of image, uploaded images skip pull stage.
+func (p *Pipelines) Entry(component string, uploaded bool) string {
+	for _, name := range p.For(component) {
+		if uploaded && name == StagePull {
+			continue
+		}
+		return name
+	}
+	return StageDone
+}
+
+// Next returns the stage after stage in pipeline of component.
+func (p *Pipelines) Next(component, stage string) string {
+	stages := p.For(component)
+	for index, name := range stages {
+		if name == stage && index+1 < len(stages) {
+			return stages[index+1]
+		}
+	}
+	return StageDone
+}
+
+// Current returns the stage image is in, stage of images created before pipelines is inferred from status.
+func (p *Pipelines) Current(image models.Image) string {
+	if len(image.Stage) != 0 {
+		return image.Stage
+	}
+	var inferred string
+	switch image.Status {
+	case models.ImageCreated, models.ImageDownloading:
+		inferred = StagePull
+	case models.ImageDownloaded, models.ImageVerifying:
+		inferred = StageVerify
+	case models.ImageVerified, models.ImageScanning, models.ImageQuarantined:
+		inferred = StageScan
+	case models.ImageScanned, models.ImagePushing, models.ImageFailed:
+		inferred = StagePush
+	default:
+		return StageDone
+	}
+	//stage may not be part of pipeline, move on to the following one
+	for !p.Has(image.ExternalComponent, inferred) && inferred != StageDone {
+		switch inferred {
+		case StagePull:
+			inferred = StageVerify
+		case StageVerify:
+			inferred = StageScan
+		case StageScan:
+			inferred = StagePush
+		default:
+			inferred = StageDone
+		}
+	}
+	return inferred
+}
+
+// Advance moves image past the completed stages, it returns whether stage of image is changed.
+func (p *Pipelines) Advance(image *models.Image, replicas []models.ImageReplica) bool {
+	current := p.Current(*image)
+	changed := current != image.Stage
+	for current != StageDone {
+		stage, ok := p.Registry.Stage(current)
+		if !ok || !stage.Completed(*image, replicas) {
+			break
+		}
+		current = p.Next(image.ExternalComponent, current)
+		changed = true
+	}
+	image.Stage = current
+	return changed
+}
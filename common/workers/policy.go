@@ -0,0 +1,50 @@
+package workers
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/omnibuildplatform/omni-repository/common/config"
+)
+
+const DefaultPolicyName = "default"
+
+// PolicyError tells image is rejected by file policy of its component.
+type PolicyError struct {
+	Detail string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Detail
+}
+
+// GetComponentPolicy returns the file policy of external component, the default policy is used when
+// component has no specific policy.
+func GetComponentPolicy(policies map[string]config.FilePolicy, component string) config.FilePolicy {
+	if policy, ok := policies[component]; ok {
+		return policy
+	}
+	return policies[DefaultPolicyName]
+}
+
+func checkPolicySize(policy config.FilePolicy, component string, size int64) error {
+	if policy.MaxSize > 0 && size > policy.MaxSize {
+		return &PolicyError{Detail: fmt.Sprintf("image size %d exceeds the maximum size %d allowed for component %s",
+			size, policy.MaxSize, component)}
+	}
+	return nil
+}
+
+func checkPolicyType(policy config.FilePolicy, component, mediaType string) error {
+	if len(policy.AllowedTypes) == 0 {
+		return nil
+	}
+	for _, pattern := range policy.AllowedTypes {
+		//pattern could be either exact media type or wildcard, e.g. "application/*"
+		if matched, _ := path.Match(pattern, mediaType); matched {
+			return nil
+		}
+	}
+	return &PolicyError{Detail: fmt.Sprintf("image type %s is not allowed for component %s, allowed types %v",
+		mediaType, component, policy.AllowedTypes)}
+}
@@ -0,0 +1,105 @@
+package workers
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/omnibuildplatform/omni-repository/common/objectstore"
+)
+
+// md5ETagPattern matches etags which are content md5 or composite md5 of multipart upload, etags of objects
+// encrypted by kms are not comparable.
+var md5ETagPattern = regexp.MustCompile(`^([0-9a-f]{32})(-([0-9]+))?$`)
+
+// RemoteMismatchError tells the remote object differs from local file, other errors are failures of verifying.
+type RemoteMismatchError struct {
+	Detail string
+}
+
+func (e *RemoteMismatchError) Error() string {
+	return e.Detail
+}
+
+// verifyRemoteObject compares size and etag of remote object with the local file, multipart etag is computed
+// locally over the same part boundaries.
+func verifyRemoteObject(ctx context.Context, store objectstore.ObjectStore, key, localPath string, partSize int64, multipart bool) error {
+	stat, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	info, err := store.Stat(ctx, key)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &RemoteMismatchError{Detail: fmt.Sprintf("object %s not found on target", key)}
+		}
+		return err
+	}
+	if info.Size != stat.Size() {
+		return &RemoteMismatchError{Detail: fmt.Sprintf("size of object %s mismatched, expected %d while actual %d", key, stat.Size(), info.Size)}
+	}
+	matches := md5ETagPattern.FindStringSubmatch(strings.ToLower(strings.Trim(info.ETag, "\"")))
+	if matches == nil {
+		return nil
+	}
+	//empty file is uploaded in a single request, its etag is plain md5 as well
+	partCount := 0
+	if multipart && stat.Size() != 0 {
+		partCount = int(stat.Size() / partSize)
+		if stat.Size()%partSize != 0 {
+			partCount++
+		}
+		if len(matches[3]) != 0 {
+			if remoteCount, _ := strconv.Atoi(matches[3]); remoteCount != partCount {
+				//uploaded with another part size, the composite etag can not be reproduced
+				return nil
+			}
+		}
+	}
+	etag, err := localETag(ctx, localPath, partSize, partCount)
+	if err != nil {
+		return err
+	}
+	if etag != matches[1] {
+		return &RemoteMismatchError{Detail: fmt.Sprintf("etag of object %s mismatched, expected %s while actual %s", key, etag, info.ETag)}
+	}
+	return nil
+}
+
+// localETag computes md5 of file when partCount is 0, otherwise md5 of concatenated part md5s.
+func localETag(ctx context.Context, localPath string, partSize int64, partCount int) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	copyBuf := make([]byte, HashingBuffer)
+	if partCount == 0 {
+		hasher := md5.New()
+		if _, err = io.CopyBuffer(hasher, newThrottledReader(ctx, file, 0), copyBuf); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(hasher.Sum(nil)), nil
+	}
+	composite := md5.New()
+	for partNumber := 1; partNumber <= partCount; partNumber++ {
+		offset, size := partRange(partNumber, partSize, stat.Size())
+		hasher := md5.New()
+		if _, err = io.CopyBuffer(hasher, newThrottledReader(ctx, io.NewSectionReader(file, offset, size), 0), copyBuf); err != nil {
+			return "", err
+		}
+		composite.Write(hasher.Sum(nil))
+	}
+	return hex.EncodeToString(composite.Sum(nil)), nil
+}
@@ -0,0 +1,67 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/omnibuildplatform/omni-repository/common/objectstore"
+)
+
+// statStore answers Stat with info, other methods of ObjectStore are not used by verifying.
+type statStore struct {
+	objectstore.ObjectStore
+	info objectstore.ObjectInfo
+}
+
+func (s *statStore) Stat(context.Context, string) (objectstore.ObjectInfo, error) {
+	return s.info, nil
+}
+
+func TestVerifyRemoteObject(t *testing.T) {
+	const emptyMD5 = "d41d8cd98f00b204e9800998ecf8427e"
+	cases := []struct {
+		name      string
+		content   string
+		partSize  int64
+		multipart bool
+		info      objectstore.ObjectInfo
+		mismatch  bool
+	}{
+		{name: "empty file pushed in single request", content: "", partSize: 4, multipart: true,
+			info: objectstore.ObjectInfo{ETag: "\"" + emptyMD5 + "\""}},
+		{name: "empty file with other etag", content: "", partSize: 4, multipart: true,
+			info: objectstore.ObjectInfo{ETag: "0cc175b9c0f1b6a831c399e269772661"}, mismatch: true},
+		{name: "empty file with content on target", content: "", partSize: 4, multipart: true,
+			info: objectstore.ObjectInfo{Size: 1, ETag: emptyMD5}, mismatch: true},
+		{name: "single part", content: "a", partSize: 4, multipart: false,
+			info: objectstore.ObjectInfo{Size: 1, ETag: "0cc175b9c0f1b6a831c399e269772661"}},
+		{name: "multipart", content: "abcdef", partSize: 4, multipart: true,
+			info: objectstore.ObjectInfo{Size: 6, ETag: "\"fa40dffba3d56c6098e0477379f300bd-2\""}},
+		{name: "multipart with other content", content: "abcdef", partSize: 4, multipart: true,
+			info: objectstore.ObjectInfo{Size: 6, ETag: "\"01c26a5d3ae3e4d38fbf2d2b1e3e6d26-2\""}, mismatch: true},
+		{name: "multipart uploaded with other part size", content: "abcdef", partSize: 4, multipart: true,
+			info: objectstore.ObjectInfo{Size: 6, ETag: "01c26a5d3ae3e4d38fbf2d2b1e3e6d26-3"}},
+		{name: "kms encrypted", content: "abcdef", partSize: 4, multipart: true,
+			info: objectstore.ObjectInfo{Size: 6, ETag: "not-md5"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			localPath := path.Join(t.TempDir(), "openEuler.iso")
+			if err := os.WriteFile(localPath, []byte(c.content), 0644); err != nil {
+				t.Fatalf("failed to write local file, %v", err)
+			}
+			err := verifyRemoteObject(context.Background(), &statStore{info: c.info}, "openEuler.iso", localPath,
+				c.partSize, c.multipart)
+			var mismatch *RemoteMismatchError
+			if errors.As(err, &mismatch) != c.mismatch {
+				t.Fatalf("unexpected mismatch state of error %v", err)
+			}
+			if !c.mismatch && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/omnibuildplatform/omni-repository/common/config"
+	"github.com/omnibuildplatform/omni-repository/common/models"
+	"github.com/omnibuildplatform/omni-repository/common/storage"
+	"go.uber.org/zap"
+)
+
+// BackfillReplicas records replicas of images published before replicas were introduced, those images were
+// pushed to the only bucket then, which is the first default target now. Paths of pushed images were rewritten
+// into object urls, they are restored to local paths so that scrub, eviction and remote verification work.
+func BackfillReplicas(config config.ImagePusher, imageStore *storage.ImageStorage, logger *zap.Logger) error {
+	afterID := 0
+	created := 0
+	for {
+		images, err := imageStore.GetPublishedImagesWithoutReplica(afterID, bootstrapBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(images) != 0 && len(config.DefaultTargets) == 0 {
+			//they are backfilled on a later start once a target is configured
+			logger.Warn("images published before replicas exist but no default publish target configured, backfill skipped")
+			return nil
+		}
+		for index := range images {
+			image := &images[index]
+			afterID = image.ID
+			replica, ok := legacyReplica(image, config.DefaultTargets[0])
+			if !ok {
+				continue
+			}
+			saved, err := imageStore.BackfillReplica(image, &replica)
+			if err != nil {
+				return errors.New(fmt.Sprintf("failed to backfill replica of image %d, %v", image.ID, err))
+			}
+			if saved {
+				created += 1
+			}
+		}
+		if len(images) < bootstrapBatchSize {
+			break
+		}
+	}
+	if created != 0 {
+		logger.Info(fmt.Sprintf("backfilled replicas of %d images published before replicas were recorded", created))
+	}
+	return nil
+}
+
+// legacyReplica derives replica on target from status and paths of image, false is returned when image
+// never got to push stage.
+func legacyReplica(image *models.Image, target string) (models.ImageReplica, bool) {
+	replica := models.ImageReplica{Target: target}
+	if strings.HasPrefix(image.ImagePath, "http") {
+		replica.Status = models.ReplicaPushed
+		replica.StatusDetail = "image pushed"
+		replica.ImageUrl = image.ImagePath
+		replica.ChecksumUrl = image.ChecksumPath
+		image.ImagePath = objectPath(image.ImagePath)
+		image.ChecksumPath = objectPath(image.ChecksumPath)
+		return replica, true
+	}
+	switch image.Status {
+	case models.ImageCreated, models.ImageDownloading, models.ImageDownloaded, models.ImageVerifying,
+		models.ImageVerified, models.ImageScanning, models.ImageScanned, models.ImagePushing:
+		replica.Status = models.ReplicaPending
+		replica.StatusDetail = "waiting for push"
+	case models.ImageFailed:
+		replica.Status = models.ReplicaFailed
+		replica.StatusDetail = "image failed before replicas were recorded"
+	default:
+		return replica, false
+	}
+	return replica, true
+}
+
+// objectPath returns path of object url, which is the local path of image before it was pushed.
+func objectPath(objectUrl string) string {
+	parsed, err := url.Parse(objectUrl)
+	if err != nil {
+		return objectUrl
+	}
+	return parsed.Path
+}
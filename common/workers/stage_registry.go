@@ -0,0 +1,164 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/omnibuildplatform/omni-repository/common/models"
+	"github.com/omnibuildplatform/omni-repository/common/storage"
+)
+
+// names of builtin pipeline stages
+const (
+	StagePull   = "pull"
+	StageVerify = "verify"
+	StageScan   = "scan"
+	StagePush   = "push"
+	// images which passed all stages of their pipeline
+	StageDone = "done"
+)
+
+// WorkerFactory creates the worker which performs work.
+type WorkerFactory func(work ImageWork) (Worker, error)
+
+// Stage is a step of image pipeline, stage is performed by works of WorkType.
+//
+// Builtin stages are completed by the image status their workers leave, and each worker starts from the
+// status the previous builtin stage leaves, which is why pipelines keep the registered order.
+type Stage struct {
+	Name     string
+	WorkType ImageWorkType
+	// Requires lists the stages which have to come earlier in any pipeline containing this stage
+	Requires []string
+	// Completed tells whether stage has finished on image, it's evaluated while image is in the stage
+	Completed func(image models.Image, replicas []models.ImageReplica) bool
+	// Plan returns the jobs required to finish the stage on image
+	Plan func(image models.Image, replicas []models.ImageReplica, now time.Time) []models.Job
+}
+
+// StageRegistry keeps pipeline stages and the workers of all work types, stages are kept in the order
+// pipelines have to follow.
+type StageRegistry struct {
+	order     []string
+	stages    map[string]Stage
+	factories map[ImageWorkType]WorkerFactory
+}
+
+// NewStageRegistry returns registry with builtin stages, workers are registered by work manager.
+func NewStageRegistry() *StageRegistry {
+	registry := &StageRegistry{
+		stages:    make(map[string]Stage),
+		factories: make(map[ImageWorkType]WorkerFactory),
+	}
+	pull := imageStage(StagePull, PullImageWork, models.ImageDownloaded)
+	plan := pull.Plan
+	pull.Plan = func(image models.Image, replicas []models.ImageReplica, now time.Time) []models.Job {
+		//uploaded images have nothing to pull
+		if len(image.SourceUrl) == 0 {
+			return nil
+		}
+		return plan(image, replicas, now)
+	}
+	registry.RegisterStage(pull)
+	registry.RegisterStage(imageStage(StageVerify, VerifyImageWork, models.ImageVerified))
+	registry.RegisterStage(imageStage(StageScan, ScanImageWork, models.ImageScanned))
+	registry.RegisterStage(Stage{
+		Name:     StagePush,
+		WorkType: PushImageWork,
+		//pusher uploads the checksum file written by verifier
+		Requires: []string{StageVerify},
+		Completed: func(image models.Image, replicas []models.ImageReplica) bool {
+			for _, replica := range replicas {
+				if replica.Status != models.ReplicaPushed && replica.Status != models.ReplicaUnpublished {
+					return false
+				}
+			}
+			return true
+		},
+		Plan: planPushJobs,
+	})
+	return registry
+}
+
+// imageStage returns stage performed by a single work of image, stage is completed when its worker moved
+// image into doneStatus.
+func imageStage(name string, workType ImageWorkType, doneStatus models.ImageStatus) Stage {
+	return Stage{
+		Name:     name,
+		WorkType: workType,
+		Completed: func(image models.Image, replicas []models.ImageReplica) bool {
+			return image.Status == doneStatus
+		},
+		Plan: func(image models.Image, replicas []models.ImageReplica, now time.Time) []models.Job {
+			return []models.Job{storage.NewJob(string(workType), image, 0, now)}
+		},
+	}
+}
+
+func (r *StageRegistry) RegisterStage(stage Stage) {
+	if _, ok := r.stages[stage.Name]; !ok {
+		r.order = append(r.order, stage.Name)
+	}
+	r.stages[stage.Name] = stage
+}
+
+func (r *StageRegistry) RegisterWorker(workType ImageWorkType, factory WorkerFactory) {
+	r.factories[workType] = factory
+}
+
+func (r *StageRegistry) Stage(name string) (Stage, bool) {
+	stage, ok := r.stages[name]
+	return stage, ok
+}
+
+// StageOf returns stage performed by works of workType.
+func (r *StageRegistry) StageOf(workType ImageWorkType) (Stage, bool) {
+	for _, stage := range r.stages {
+		if stage.WorkType == workType {
+			return stage, true
+		}
+	}
+	return Stage{}, false
+}
+
+func (r *StageRegistry) NewWorker(work ImageWork) (Worker, error) {
+	factory, ok := r.factories[work.Type]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unsupported image work %s", work.Type))
+	}
+	return factory(work)
+}
+
+// index returns position of stage in the registered order.
+func (r *StageRegistry) index(name string) int {
+	for index, registered := range r.order {
+		if registered == name {
+			return index
+		}
+	}
+	return -1
+}
+
+// Validate checks every stage has its worker registered.
+func (r *StageRegistry) Validate() error {
+	for name, stage := range r.stages {
+		if _, ok := r.factories[stage.WorkType]; !ok {
+			return errors.New(fmt.Sprintf("no worker registered for stage %s", name))
+		}
+	}
+	return nil
+}
+
+// WorkTypes returns the work types which have their worker registered, sorted by name.
+func (r *StageRegistry) WorkTypes() []ImageWorkType {
+	workTypes := make([]ImageWorkType, 0, len(r.factories))
+	for workType := range r.factories {
+		workTypes = append(workTypes, workType)
+	}
+	sort.Slice(workTypes, func(i, j int) bool {
+		return workTypes[i] < workTypes[j]
+	})
+	return workTypes
+}
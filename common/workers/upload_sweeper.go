@@ -0,0 +1,72 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/omnibuildplatform/omni-repository/common/config"
+	"github.com/omnibuildplatform/omni-repository/common/objectstore"
+	"github.com/omnibuildplatform/omni-repository/common/storage"
+	"go.uber.org/zap"
+)
+
+// UploadSweeper aborts incomplete multipart uploads left on publish targets, uploads still referenced by
+// replicas are kept for resuming.
+type UploadSweeper struct {
+	ImageStore *storage.ImageStorage
+	Logger     *zap.Logger
+	Config     config.ImagePusher
+}
+
+func NewUploadSweeper(config config.ImagePusher, imageStore *storage.ImageStorage, logger *zap.Logger) (*UploadSweeper, error) {
+	return &UploadSweeper{
+		ImageStore: imageStore,
+		Logger:     logger,
+		Config:     config,
+	}, nil
+}
+
+func (s *UploadSweeper) DoWork(ctx context.Context) error {
+	for name, target := range s.Config.Targets {
+		if err := s.sweepTarget(ctx, name, target); err != nil {
+			s.Logger.Error(fmt.Sprintf("failed to sweep incomplete uploads on target %s, %v", name, err))
+		}
+	}
+	return nil
+}
+
+func (s *UploadSweeper) sweepTarget(ctx context.Context, name string, target config.PublishTarget) error {
+	store, err := objectstore.NewObjectStore(target)
+	if err != nil {
+		return err
+	}
+	uploadIDs, err := s.ImageStore.GetReplicaUploadIDs(name)
+	if err != nil {
+		return err
+	}
+	referenced := make(map[string]bool, len(uploadIDs))
+	for _, id := range uploadIDs {
+		referenced[id] = true
+	}
+	uploads, err := store.ListMultipartUploads(ctx, "")
+	if err != nil {
+		return err
+	}
+	before := time.Now().Add(-time.Duration(s.Config.StaleUploadAge) * time.Second)
+	for _, upload := range uploads {
+		if referenced[upload.UploadID] || upload.Initiated.After(before) {
+			continue
+		}
+		if err := store.AbortMultipart(ctx, upload.Key, upload.UploadID); err != nil {
+			s.Logger.Error(fmt.Sprintf("failed to abort stale upload %s for file %s on target %s, %v",
+				upload.UploadID, upload.Key, name, err))
+			continue
+		}
+		s.Logger.Info(fmt.Sprintf("stale upload %s for file %s on target %s aborted", upload.UploadID, upload.Key, name))
+	}
+	return nil
+}
+
+func (s *UploadSweeper) Close() {
+}
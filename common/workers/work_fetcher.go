@@ -4,124 +4,247 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/omnibuildplatform/omni-repository/common/config"
+	"github.com/omnibuildplatform/omni-repository/common/models"
 	"github.com/omnibuildplatform/omni-repository/common/storage"
 	"go.uber.org/zap"
-	"sync"
+	"time"
 )
 
-var fetchDownloading sync.Once
+const bootstrapBatchSize = 100
 
 type WorkFetcher struct {
-	ImageStore  *storage.ImageStorage
-	Logger      *zap.Logger
-	WorkChannel chan ImageWork
+	ImageStore *storage.ImageStorage
+	Logger     *zap.Logger
+	Pools      []*WorkPool
+	Pipelines  *Pipelines
+	InFlight   *InFlightWorks
+	Scheduler  *JobScheduler
+	Retry      config.Retry
+	Signal     *WorkSignal
+	// instance id recorded on claimed jobs
+	Owner string
+	// set once interrupted jobs are requeued, initialization is retried on next round until then
+	initialized bool
 }
 
-func NewWorkFetcher(imageStore *storage.ImageStorage, logger *zap.Logger, workCh chan ImageWork) (*WorkFetcher, error) {
+func NewWorkFetcher(imageStore *storage.ImageStorage, logger *zap.Logger, pools []*WorkPool, pipelines *Pipelines, inFlight *InFlightWorks, scheduler *JobScheduler, retry config.Retry, signal *WorkSignal, owner string) (*WorkFetcher, error) {
 	return &WorkFetcher{
-		ImageStore:  imageStore,
-		Logger:      logger,
-		WorkChannel: workCh,
+		ImageStore: imageStore,
+		Logger:     logger,
+		Pools:      pools,
+		Pipelines:  pipelines,
+		InFlight:   inFlight,
+		Scheduler:  scheduler,
+		Retry:      retry,
+		Signal:     signal,
+		Owner:      owner,
 	}, nil
 }
 
-func (r *WorkFetcher) DoWork(ctx context.Context) error {
-	//1. fetch image which are downloading before start
-	fetchWorkingFailed := false
-	fetchDownloading.Do(func() {
-		r.Logger.Info("==========initialize work: fetch downloading work==========")
-		images, err := r.ImageStore.GetDownloadingImages()
+// initialize requeues jobs interrupted by last shutdown and plans jobs for images which don't have one.
+func (r *WorkFetcher) initialize() error {
+	r.Logger.Info("==========initialize work: rename legacy work types==========")
+	renamed, err := r.ImageStore.RenameWorkType(string(LegacySignImageWork), string(VerifyImageWork))
+	if err != nil {
+		return err
+	}
+	if renamed != 0 {
+		r.Logger.Info(fmt.Sprintf("renamed %d jobs from %s to %s", renamed, LegacySignImageWork, VerifyImageWork))
+	}
+	r.Logger.Info("==========initialize work: reset running jobs==========")
+	count, err := r.ImageStore.ResetRunningJobs(r.Owner)
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		r.Logger.Info(fmt.Sprintf("found %d unfinished jobs, requeued", count))
+	}
+	r.Logger.Info("==========initialize work: plan jobs for unfinished images==========")
+	afterID := 0
+	for {
+		images, err := r.ImageStore.GetImagesWithoutActiveJob(afterID, bootstrapBatchSize)
 		if err != nil {
-			r.Logger.Error("failed to fetch downloading work from database")
-			fetchWorkingFailed = true
+			return err
 		}
-		if len(images) != 0 {
-			r.Logger.Info(fmt.Sprintf("found %d unfinished downloading images for download", len(images)))
-			for _, image := range images {
-				r.WorkChannel <- ImageWork{
-					Image: image,
-					Type:  PullImageWork,
-				}
+		for _, image := range images {
+			afterID = image.ID
+			if err := ScheduleImageJobs(r.ImageStore, image.ID, r.Pipelines, nil); err != nil {
+				r.Logger.Error(fmt.Sprintf("failed to plan jobs for image %d, %v", image.ID, err))
 			}
 		}
-		r.Logger.Info("==========initialize work: fetch pushing work==========")
-		images, err = r.ImageStore.GetPushingImages()
-		if err != nil {
-			r.Logger.Error("failed to fetch pushing work from database")
-			fetchWorkingFailed = true
+		if len(images) < bootstrapBatchSize {
+			return nil
 		}
-		if len(images) != 0 {
-			r.Logger.Info(fmt.Sprintf("found %d unfinished pushing images for download", len(images)))
-			for _, image := range images {
-				r.WorkChannel <- ImageWork{
-					Image: image,
-					Type:  PushImageWork,
-				}
-			}
+	}
+}
+
+func (r *WorkFetcher) DoWork(ctx context.Context) error {
+	//1. requeue interrupted jobs before start
+	if !r.initialized {
+		if err := r.initialize(); err != nil {
+			return errors.New(fmt.Sprintf("failed to initialize jobs from database, %v", err))
 		}
-	})
-	if fetchWorkingFailed {
-		return errors.New("failed to fetch downloading work from database")
+		r.initialized = true
 	}
-	//2. fetch image which are not downloaded
-	images, err := r.ImageStore.GetImageForDownload(20)
+	//2. claim due jobs for each pool, a busy pool doesn't hold up works of others
+	paused, err := r.ImageStore.GetPausedWorkTypes()
 	if err != nil {
 		return err
 	}
-	if len(images) != 0 {
-		r.Logger.Info(fmt.Sprintf("found %d images for download", len(images)))
-		for _, image := range images {
-			r.WorkChannel <- ImageWork{
-				Image: image,
-				Type:  PullImageWork,
-			}
+	for _, pool := range r.Pools {
+		if err := r.claimWorks(pool, paused); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimWorks claims due jobs of pool by priority and fair share, no more than its queue can take, jobs of
+// paused work types are left in queue.
+func (r *WorkFetcher) claimWorks(pool *WorkPool, paused map[string]bool) error {
+	free := cap(pool.Channel) - len(pool.Channel)
+	if free <= 0 {
+		return nil
+	}
+	var types []string
+	for _, workType := range pool.Types {
+		if !paused[string(workType)] {
+			types = append(types, string(workType))
 		}
 	}
-	//3. fetch image which are not verified
-	images, err = r.ImageStore.GetImageForVerify(20)
+	if len(types) == 0 {
+		return nil
+	}
+	jobs, err := r.ImageStore.ClaimJobs(r.Owner, free, types, r.Scheduler.Pick)
 	if err != nil {
 		return err
 	}
-	if len(images) != 0 {
-		r.Logger.Info(fmt.Sprintf("found %d images for verify", len(images)))
-		for _, image := range images {
-			r.WorkChannel <- ImageWork{
-				Image: image,
-				Type:  SignImageWork,
-			}
+	if len(jobs) != 0 {
+		r.Logger.Info(fmt.Sprintf("claimed %d jobs for %s pool", len(jobs), pool.Name))
+	}
+	for index := range jobs {
+		work, err := r.loadWork(jobs[index])
+		if err != nil {
+			r.Logger.Error(fmt.Sprintf("failed to load work of job %d, %v", jobs[index].ID, err))
+			r.CompleteJob(jobs[index], err)
+			continue
 		}
+		if work == nil {
+			r.skipJob(jobs[index], "")
+			continue
+		}
+		if !r.InFlight.Acquire(*work) {
+			//the running one plans following works when it finishes
+			r.Logger.Warn(fmt.Sprintf("%s of image %d conflicts with works in flight, job %d skipped", work.Type, work.Image.ID, jobs[index].ID))
+			r.skipJob(jobs[index], "conflicting work already in flight")
+			continue
+		}
+		pool.Channel <- *work
 	}
+	return nil
+}
 
-	// 4. fetch image for pushing
-	images, err = r.ImageStore.GetImageForPush(20)
+func (r *WorkFetcher) skipJob(job models.Job, detail string) {
+	job.State = models.JobSkipped
+	job.LastError = detail
+	if err := r.ImageStore.FinishJob(&job); err != nil {
+		r.Logger.Error(fmt.Sprintf("failed to skip job %d, %v", job.ID, err))
+	}
+}
+
+// loadWork returns nil when job is no longer required by its image.
+func (r *WorkFetcher) loadWork(job models.Job) (*ImageWork, error) {
+	//objects of deleted images still need to be removed from targets
+	image, err := r.ImageStore.GetImageByIDIncludingDeleted(job.ImageID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if len(images) != 0 {
-		r.Logger.Info(fmt.Sprintf("found %d images for push", len(images)))
-		for _, image := range images {
-			r.WorkChannel <- ImageWork{
-				Image: image,
-				Type:  PushImageWork,
-			}
+	replicas, err := r.ImageStore.GetReplicasByImageID(job.ImageID)
+	if err != nil {
+		return nil, err
+	}
+	if !JobRequired(job, image, replicas, r.Pipelines) {
+		return nil, nil
+	}
+	work := ImageWork{
+		Image: image,
+		Type:  ImageWorkType(job.Type),
+		Job:   job,
+	}
+	if job.ReplicaID != 0 {
+		work.Replica, err = r.ImageStore.GetReplicaByID(job.ReplicaID)
+		if err != nil {
+			return nil, err
 		}
 	}
+	return &work, nil
+}
+
+// CompleteJob records result of job and queues the works its image requires next.
+func (r *WorkFetcher) CompleteJob(job models.Job, workErr error) {
+	job.State = models.JobSucceeded
+	job.LastError = ""
+	if workErr != nil {
+		job.State = models.JobFailed
+		job.LastError = workErr.Error()
+	}
+	if err := r.ImageStore.FinishJob(&job); err != nil {
+		r.Logger.Error(fmt.Sprintf("failed to update state of job %d, %v", job.ID, err))
+	}
+	if workErr != nil {
+		r.recordFailure(job, workErr)
+	}
+	if err := ScheduleImageJobs(r.ImageStore, job.ImageID, r.Pipelines, &job); err != nil {
+		r.Logger.Error(fmt.Sprintf("failed to plan jobs for image %d, %v", job.ImageID, err))
+		return
+	}
+	//next stage starts without waiting for sync
+	r.Signal.Notify()
+}
+
+// RequeueJob puts job back to queue without planning, used for works which are interrupted, never started or
+// waiting for an unavailable dependency, job is claimed again after delay.
+func (r *WorkFetcher) RequeueJob(job models.Job, reason string, delay time.Duration) {
+	job.LastError = reason
+	if err := r.ImageStore.RequeueJob(&job, time.Now().Add(delay)); err != nil {
+		r.Logger.Error(fmt.Sprintf("failed to requeue job %d, %v", job.ID, err))
+	}
+}
+
+// recordFailure saves the stage and class of failure when job failed its image, retry is scheduled
+// according to retry policy.
+func (r *WorkFetcher) recordFailure(job models.Job, workErr error) {
+	image, err := r.ImageStore.GetImageByIDIncludingDeleted(job.ImageID)
+	if err != nil || image.Deleted || image.Status != models.ImageFailed {
+		return
+	}
+	ApplyRetryPolicy(r.Retry, &image, ImageWorkType(job.Type), workErr)
+	if err = r.ImageStore.UpdateImageRetry(&image); err != nil {
+		r.Logger.Error(fmt.Sprintf("failed to save failure of image %d, %v", image.ID, err))
+		return
+	}
+	if image.RetryTime != nil {
+		r.Logger.Info(fmt.Sprintf("image %d failed in %s with %s failure, attempt %d will start at %s", image.ID,
+			image.FailedStage, image.FailureClass, image.Attempts, image.RetryTime.Format(time.RFC3339)))
+	}
+}
 
-	// 5. fetch image for clean
-	images, err = r.ImageStore.GetImageForClean(20)
+// RenewLeases keeps jobs claimed by this instance owned, and requeues jobs of instances which stopped
+// renewing theirs.
+func (r *WorkFetcher) RenewLeases() {
+	if err := r.ImageStore.RenewJobLeases(r.Owner); err != nil {
+		r.Logger.Error(fmt.Sprintf("failed to renew leases of running jobs, %v", err))
+	}
+	count, err := r.ImageStore.ResetExpiredJobs()
 	if err != nil {
-		return err
+		r.Logger.Error(fmt.Sprintf("failed to requeue jobs with expired lease, %v", err))
+		return
 	}
-	if len(images) != 0 {
-		r.Logger.Info(fmt.Sprintf("found %d images for clean", len(images)))
-		for _, image := range images {
-			r.WorkChannel <- ImageWork{
-				Image: image,
-				Type:  CleanImageWork,
-			}
-		}
+	if count != 0 {
+		r.Logger.Warn(fmt.Sprintf("requeued %d jobs abandoned by other instances", count))
+		r.Signal.Notify()
 	}
-	return nil
 }
 
 func (r *WorkFetcher) Close() error {
@@ -0,0 +1,62 @@
+package workers
+
+import (
+	"github.com/omnibuildplatform/omni-repository/common/config"
+)
+
+// names of work pools
+const (
+	PoolPull   = "pull"
+	PoolVerify = "verify"
+	PoolPush   = "push"
+	PoolClean  = "clean"
+)
+
+// WorkPool runs works of its types on its own goroutines and queue, so that network bound transfers and
+// cpu bound hashing don't hold each other up.
+type WorkPool struct {
+	Name  string
+	Types []ImageWorkType
+	Size  int
+	// Parallelism is the number of blocks or parts a single work of pool transfers or hashes at the same time
+	Parallelism int
+	Channel     chan ImageWork
+}
+
+// NewWorkPools returns pools sized by config, threads is used for sizes and parallelism not configured.
+func NewWorkPools(pools config.Pools, threads int) []*WorkPool {
+	newPool := func(name string, size, parallelism int, types ...ImageWorkType) *WorkPool {
+		if size <= 0 {
+			size = threads
+		}
+		if parallelism <= 0 {
+			parallelism = threads
+		}
+		return &WorkPool{
+			Name:        name,
+			Types:       types,
+			Size:        size,
+			Parallelism: parallelism,
+			Channel:     make(chan ImageWork, size*4),
+		}
+	}
+	return []*WorkPool{
+		newPool(PoolPull, pools.Pull, pools.PullParallelism, PullImageWork),
+		//scanning streams image content same as hashing
+		newPool(PoolVerify, pools.Verify, pools.VerifyParallelism, VerifyImageWork, ScanImageWork),
+		newPool(PoolPush, pools.Push, pools.PushParallelism, PushImageWork, UnpublishImageWork, VerifyRemoteImageWork),
+		newPool(PoolClean, pools.Clean, 1, CleanImageWork),
+	}
+}
+
+// PoolOf returns the pool running works of workType.
+func PoolOf(pools []*WorkPool, workType ImageWorkType) *WorkPool {
+	for _, pool := range pools {
+		for _, poolType := range pool.Types {
+			if poolType == workType {
+				return pool
+			}
+		}
+	}
+	return nil
+}
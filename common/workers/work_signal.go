@@ -0,0 +1,25 @@
+package workers
+
+// WorkSignal wakes work manager up to fetch jobs right after they are queued, signals sent while a fetch
+// is pending are coalesced into it. Periodic sync still picks up jobs queued by other instances.
+type WorkSignal struct {
+	ch chan struct{}
+}
+
+func NewWorkSignal() *WorkSignal {
+	return &WorkSignal{
+		ch: make(chan struct{}, 1),
+	}
+}
+
+// Notify never blocks.
+func (s *WorkSignal) Notify() {
+	select {
+	case s.ch <- struct{}{}:
+	default:
+	}
+}
+
+func (s *WorkSignal) C() <-chan struct{} {
+	return s.ch
+}
@@ -0,0 +1,96 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+type progressKey struct{}
+
+// WorkerSlot is the state of a goroutine performing image works.
+type WorkerSlot struct {
+	Index     int
+	Pool      string
+	Busy      bool
+	Work      ImageWork
+	StartTime time.Time
+	progress  *atomic.Int64
+}
+
+// BytesProcessed returns bytes downloaded, hashed or uploaded by the current work of slot.
+func (s WorkerSlot) BytesProcessed() int64 {
+	if s.progress == nil {
+		return 0
+	}
+	return s.progress.Load()
+}
+
+// WorkerMonitor keeps what each worker slot of this instance is doing, it's shared by apis and work manager.
+type WorkerMonitor struct {
+	lock     sync.Mutex
+	instance string
+	slots    []WorkerSlot
+}
+
+func NewWorkerMonitor() *WorkerMonitor {
+	return &WorkerMonitor{}
+}
+
+func (m *WorkerMonitor) SetInstance(instance string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.instance = instance
+}
+
+// Instance returns id of the instance whose slots are monitored.
+func (m *WorkerMonitor) Instance() string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.instance
+}
+
+// AddSlot registers an idle slot of pool and returns its index.
+func (m *WorkerMonitor) AddSlot(pool string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.slots = append(m.slots, WorkerSlot{Index: len(m.slots), Pool: pool})
+	return len(m.slots) - 1
+}
+
+// Start marks slot busy with work, the returned context carries the progress counter of work.
+func (m *WorkerMonitor) Start(ctx context.Context, slot int, work ImageWork) context.Context {
+	progress := atomic.NewInt64(0)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if slot >= 0 && slot < len(m.slots) {
+		m.slots[slot] = WorkerSlot{Index: slot, Pool: m.slots[slot].Pool, Busy: true, Work: work, StartTime: time.Now(), progress: progress}
+	}
+	return context.WithValue(ctx, progressKey{}, progress)
+}
+
+func (m *WorkerMonitor) Finish(slot int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if slot >= 0 && slot < len(m.slots) {
+		m.slots[slot] = WorkerSlot{Index: slot, Pool: m.slots[slot].Pool}
+	}
+}
+
+func (m *WorkerMonitor) Slots() []WorkerSlot {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	slots := make([]WorkerSlot, len(m.slots))
+	copy(slots, m.slots)
+	return slots
+}
+
+// AddProgress counts bytes processed by the work running on ctx, it's a no-op for works not started
+// by worker slots, e.g. scrubs.
+func AddProgress(ctx context.Context, bytes int64) {
+	if progress, ok := ctx.Value(progressKey{}).(*atomic.Int64); ok {
+		progress.Add(bytes)
+	}
+}
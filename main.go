@@ -6,9 +6,10 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/omnibuildplatform/omni-repository/common/messages"
+	"github.com/omnibuildplatform/omni-repository/common/scanner"
+	"github.com/omnibuildplatform/omni-repository/common/workers"
 
 	"github.com/omnibuildplatform/omni-repository/common"
 
@@ -78,13 +79,29 @@ func main() {
 	}
 
 	app.Logger.Info("initialize message worker successfully")
+	if workers.ApplyLegacyTarget(&app.AppConfig.WorkManager.Workers.ImagePusher) {
+		app.Logger.Warn(fmt.Sprintf("endpoint, ak, sk and bucket of imagerPusher are deprecated, they are used as publish target %s, "+
+			"configure imagerPusher.targets instead", workers.LegacyTargetName))
+	}
+	//shared by apis and work manager to cancel works of images
+	imageContexts := workers.NewImageContexts()
+	//wakes work manager up when apis queue jobs
+	workSignal := workers.NewWorkSignal()
+	workerMonitor := workers.NewWorkerMonitor()
+	pipelines, err := workers.NewPipelines(workers.NewStageRegistry(), app.AppConfig.WorkManager.Pipelines,
+		scanner.Enabled(app.AppConfig.WorkManager.Workers.ImageScanner))
+	if err != nil {
+		app.Logger.Error(fmt.Sprintf("invalid pipelines %v", err))
+		os.Exit(1)
+	}
 	repoManager, err = application.NewRepositoryManager(
 		globalContext.ctx,
 		app.AppConfig.RepoManager,
+		app.AppConfig.WorkManager,
 		application.PublicEngine().Group("/"),
 		application.InternalEngine().Group("/"),
 		imageStore,
-		app.AppConfig.ServerConfig.DataFolder, app.Logger)
+		app.AppConfig.ServerConfig.DataFolder, app.Logger, notifier, imageContexts, pipelines, workSignal, workerMonitor)
 	if err != nil {
 		app.Logger.Error(fmt.Sprintf("failed to initialize repository manager %v", err))
 		os.Exit(1)
@@ -95,12 +112,12 @@ func main() {
 		os.Exit(1)
 	}
 	app.Logger.Info("repo manager fully start up")
-	workManager, err := application.NewWorkManager(
+	workManager, err = application.NewWorkManager(
 		globalContext.ctx,
 		app.AppConfig.WorkManager,
 		app.Logger,
 		imageStore,
-		app.AppConfig.ServerConfig.DataFolder, notifier)
+		app.AppConfig.ServerConfig.DataFolder, notifier, imageContexts, pipelines, workSignal, workerMonitor)
 	if err != nil {
 		app.Logger.Error(fmt.Sprintf("failed to start work manager %v", err))
 		os.Exit(1)
@@ -136,24 +153,24 @@ func handleSignals(c chan os.Signal) {
 	// sync logs
 	_ = app.Logger.Sync()
 
-	if globalContext != nil {
-		globalContext.cancel()
-	}
-
 	if repoManager != nil {
 		repoManager.Close()
 	}
+	//drain works before cancelling global context, database is still needed for checkpoints
 	if workManager != nil {
 		workManager.Close()
 	}
+	if globalContext != nil {
+		globalContext.cancel()
+	}
 	if store != nil {
 		store.Close()
 	}
 	if notifier != nil {
 		notifier.Close()
 	}
-	time.Sleep(3 * time.Second)
 	application.Close()
+	_ = app.Logger.Sync()
 	//sleep and exit
 	color.Info.Println("\nGoodBye...")
 